@@ -0,0 +1,373 @@
+//
+// Copyright (C) 2017 Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/zentrope/tools/lib"
+)
+
+// profile is what to put in a cert, from a JSON file and/or flags.
+type profile struct {
+	Type         string   `json:"type"` // server or client
+	CommonName   string   `json:"commonName,omitempty"`
+	Organization string   `json:"organization,omitempty"`
+	SANs         []string `json:"sans,omitempty"`
+	Key          string   `json:"key"`
+	Bits         int      `json:"bits,omitempty"`
+	Days         int      `json:"days"`
+	EKUs         []string `json:"ekus,omitempty"`
+}
+
+func defaultProfile() profile {
+	return profile{Type: "server", Key: lib.KeyECDSA, Days: 90}
+}
+
+func splitList(value string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// profileFlags are the flags that override a profile's fields.
+type profileFlags struct {
+	file, kind, cn, org, sans, key, ekus string
+	bits, days                           int
+}
+
+func (pf *profileFlags) addFlags(fs *flag.FlagSet) {
+	fs.StringVar(&pf.file, "profile", "", "JSON profile to start from (flags override it).")
+	fs.StringVar(&pf.kind, "type", "server", "Kind of cert: server or client.")
+	fs.StringVar(&pf.cn, "cn", "", "Subject common name (default the first SAN or the cert name).")
+	fs.StringVar(&pf.org, "o", "", "Subject organization.")
+	fs.StringVar(&pf.sans, "san", "", "Comma separated DNS names, IPs, emails or URIs.")
+	fs.StringVar(&pf.key, "key", lib.KeyECDSA, "Key algorithm: rsa, ecdsa or ed25519.")
+	fs.IntVar(&pf.bits, "bits", 0, "RSA modulus size (2048) or ECDSA curve (256, 384, 521).")
+	fs.IntVar(&pf.days, "days", 90, "Days the cert is valid.")
+	fs.StringVar(&pf.ekus, "eku", "", "Comma separated extended key usages (default by type).")
+}
+
+// profile loads the profile file, if any, then applies the flags that
+// were given on the command line.
+func (pf *profileFlags) profile(fs *flag.FlagSet) (profile, error) {
+	p := defaultProfile()
+
+	if pf.file != "" {
+		data, err := ioutil.ReadFile(pf.file)
+		if err != nil {
+			return p, err
+		}
+		if err := json.Unmarshal(data, &p); err != nil {
+			return p, fmt.Errorf("%v: %v", pf.file, err)
+		}
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "type":
+			p.Type = pf.kind
+		case "cn":
+			p.CommonName = pf.cn
+		case "o":
+			p.Organization = pf.org
+		case "san":
+			p.SANs = splitList(pf.sans)
+		case "key":
+			p.Key = pf.key
+		case "bits":
+			p.Bits = pf.bits
+		case "days":
+			p.Days = pf.days
+		case "eku":
+			p.EKUs = splitList(pf.ekus)
+		}
+	})
+
+	if p.Type != "server" && p.Type != "client" {
+		return p, fmt.Errorf("unknown cert type '%v' (use server or client)", p.Type)
+	}
+	if p.Days < 1 {
+		return p, fmt.Errorf("bad validity of %v days", p.Days)
+	}
+
+	return p, nil
+}
+
+func randomSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// template builds the cert for name described by p.
+func (p profile) template(name string) (*x509.Certificate, error) {
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	cert := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: p.CommonName},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.AddDate(0, 0, p.Days),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+	if cert.Subject.CommonName == "" {
+		cert.Subject.CommonName = name
+		if len(p.SANs) > 0 {
+			cert.Subject.CommonName = p.SANs[0]
+		}
+	}
+	if p.Organization != "" {
+		cert.Subject.Organization = []string{p.Organization}
+	}
+
+	sans := p.SANs
+	if len(sans) == 0 && p.Type == "server" {
+		sans = []string{cert.Subject.CommonName}
+	}
+	alt, err := lib.ParseAltNames(sans)
+	if err != nil {
+		return nil, err
+	}
+	cert.DNSNames = alt.DNSNames
+	cert.EmailAddresses = alt.EmailAddresses
+	cert.IPAddresses = alt.IPAddresses
+	cert.URIs = alt.URIs
+
+	ekus := p.EKUs
+	if len(ekus) == 0 {
+		ekus = []string{p.Type + "Auth"}
+	}
+	for _, name := range ekus {
+		eku, err := lib.ParseExtKeyUsage(name)
+		if err != nil {
+			return nil, err
+		}
+		cert.ExtKeyUsage = append(cert.ExtKeyUsage, eku)
+	}
+
+	return cert, nil
+}
+
+// issue signs a cert for name with key, writes it out and records it.
+func (s *store) issue(name string, p profile, key crypto.Signer) (*record, error) {
+	tmpl, err := p.template(name)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := key.(*rsa.PrivateKey); ok {
+		tmpl.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
+	if s.state.CRLURL != "" {
+		tmpl.CRLDistributionPoints = []string{s.state.CRLURL}
+	}
+	if tmpl.NotAfter.After(s.cert.NotAfter) {
+		tmpl.NotAfter = s.cert.NotAfter
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, s.cert, key.Public(), s.key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(s.path(certsDir), 0755); err != nil {
+		return nil, err
+	}
+	if err := writeKey(s.certPath(name, "-key"), key); err != nil {
+		return nil, err
+	}
+	if err := writePEM(s.certPath(name, ""), "CERTIFICATE", der, 0644); err != nil {
+		return nil, err
+	}
+	chain := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.cert.Raw})...)
+	if err := ioutil.WriteFile(s.certPath(name, "-chain"), chain, 0644); err != nil {
+		return nil, err
+	}
+
+	s.state.Certs = append(s.state.Certs, record{
+		Name:      name,
+		Serial:    tmpl.SerialNumber.String(),
+		Profile:   p,
+		NotBefore: tmpl.NotBefore,
+		NotAfter:  tmpl.NotAfter,
+	})
+	if err := s.save(); err != nil {
+		return nil, err
+	}
+
+	return &s.state.Certs[len(s.state.Certs)-1], nil
+}
+
+func (s *store) printIssued(r *record) {
+	fmt.Printf("Issued %v (serial %v), valid until %v\n", r.Name, r.Serial, r.NotAfter.Format(time.RFC3339))
+	fmt.Printf("  cert:  %v\n", s.certPath(r.Name, ""))
+	fmt.Printf("  key:   %v\n", s.certPath(r.Name, "-key"))
+	fmt.Printf("  chain: %v\n", s.certPath(r.Name, "-chain"))
+}
+
+func issueUsage(fs *flag.FlagSet) func() {
+	return func() {
+		fmt.Println("USAGE: certgen issue [options] name")
+		fmt.Println("")
+		fmt.Println("Issue a server or client cert, written to certs/name.pem with its")
+		fmt.Println("key and a chain including the root.")
+		fmt.Println("")
+		fmt.Println("OPTIONS:")
+		fs.PrintDefaults()
+	}
+}
+
+func issueMain(args []string) int {
+	var dir string
+	var pf profileFlags
+
+	fs := flag.NewFlagSet("issue", flag.ExitOnError)
+	fs.StringVar(&dir, "dir", defaultDir, "CA directory.")
+	pf.addFlags(fs)
+	fs.Usage = issueUsage(fs)
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	name := fs.Arg(0)
+
+	s, err := openStore(dir)
+	if err == nil {
+		err = checkName(name)
+	}
+	if err != nil {
+		fmt.Printf("ERROR: %v\n", err)
+		return 1
+	}
+
+	if r := s.latest(name); r != nil && !r.Revoked {
+		fmt.Printf("ERROR: '%v' already issued (use reissue or revoke)\n", name)
+		return 1
+	}
+
+	p, err := pf.profile(fs)
+	if err != nil {
+		fmt.Printf("ERROR: %v\n", err)
+		return 1
+	}
+
+	key, err := lib.GenerateKey(p.Key, p.Bits)
+	if err != nil {
+		fmt.Printf("ERROR: %v\n", err)
+		return 1
+	}
+
+	r, err := s.issue(name, p, key)
+	if err != nil {
+		fmt.Printf("ERROR: %v\n", err)
+		return 1
+	}
+
+	s.printIssued(r)
+	return 0
+}
+
+func reissueUsage(fs *flag.FlagSet) func() {
+	return func() {
+		fmt.Println("USAGE: certgen reissue [options] name")
+		fmt.Println("")
+		fmt.Println("Issue a new cert for name with the same profile, keeping its key")
+		fmt.Println("unless -rekey is given. The old cert is not revoked.")
+		fmt.Println("")
+		fmt.Println("OPTIONS:")
+		fs.PrintDefaults()
+	}
+}
+
+func reissueMain(args []string) int {
+	var dir string
+	var rekey bool
+	var days int
+
+	fs := flag.NewFlagSet("reissue", flag.ExitOnError)
+	fs.StringVar(&dir, "dir", defaultDir, "CA directory.")
+	fs.BoolVar(&rekey, "rekey", false, "Generate a new key rather than reusing the current one.")
+	fs.IntVar(&days, "days", 0, "Days the cert is valid (default as before).")
+	fs.Usage = reissueUsage(fs)
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	name := fs.Arg(0)
+
+	s, err := openStore(dir)
+	if err != nil {
+		fmt.Printf("ERROR: %v\n", err)
+		return 1
+	}
+
+	previous := s.latest(name)
+	if previous == nil {
+		fmt.Printf("ERROR: nothing issued for '%v'\n", name)
+		return 1
+	}
+
+	p := previous.Profile
+	if days > 0 {
+		p.Days = days
+	}
+
+	var key crypto.Signer
+	if rekey {
+		key, err = lib.GenerateKey(p.Key, p.Bits)
+	} else {
+		key, err = readKey(s.certPath(name, "-key"))
+	}
+	if err != nil {
+		fmt.Printf("ERROR: %v\n", err)
+		return 1
+	}
+
+	r, err := s.issue(name, p, key)
+	if err != nil {
+		fmt.Printf("ERROR: %v\n", err)
+		return 1
+	}
+
+	s.printIssued(r)
+	return 0
+}
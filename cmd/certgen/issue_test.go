@@ -0,0 +1,156 @@
+//
+// Copyright (C) 2017 Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/zentrope/tools/lib"
+)
+
+// run runs a certgen command, failing the test unless it succeeds.
+func run(t *testing.T, command func([]string) int, args ...string) {
+	t.Helper()
+	if code := command(args); code != 0 {
+		t.Fatalf("certgen %v: exit %v", strings.Join(args, " "), code)
+	}
+}
+
+func readCert(t *testing.T, path string) *x509.Certificate {
+	t.Helper()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs, err := lib.ParseCertificates(data, "")
+	if err != nil {
+		t.Fatalf("%v: %v", path, err)
+	}
+	return certs[0]
+}
+
+// newCA inits a CA in a temporary directory.
+func newCA(t *testing.T) *store {
+	t.Helper()
+	dir := t.TempDir()
+	run(t, initMain, "-dir", dir, "-cn", "Test Root")
+	s, err := openStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestIssue(t *testing.T) {
+	s := newCA(t)
+	run(t, issueMain, "-dir", s.dir, "-san", "www.example.com, 192.0.2.1, admin@example.com",
+		"-eku", "serverAuth,clientAuth", "web")
+
+	ca := readCert(t, s.path(caCertFile))
+	cert := readCert(t, s.certPath("web", ""))
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	_, err := cert.Verify(x509.VerifyOptions{Roots: roots, DNSName: "www.example.com",
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cert.Subject.CommonName != "www.example.com" || !reflect.DeepEqual(cert.DNSNames, []string{"www.example.com"}) ||
+		len(cert.IPAddresses) != 1 || !cert.IPAddresses[0].Equal(net.ParseIP("192.0.2.1")) ||
+		!reflect.DeepEqual(cert.EmailAddresses, []string{"admin@example.com"}) {
+		t.Errorf("got CN %v, SANs %v %v %v", cert.Subject.CommonName, cert.DNSNames, cert.IPAddresses,
+			cert.EmailAddresses)
+	}
+	want := []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	if !reflect.DeepEqual(cert.ExtKeyUsage, want) || cert.IsCA {
+		t.Errorf("got EKUs %v (CA %v), want %v", cert.ExtKeyUsage, cert.IsCA, want)
+	}
+
+	// The chain is the cert then the root, and the key matches.
+	data, err := ioutil.ReadFile(s.certPath("web", "-chain"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := lib.ParseCertificates(data, "")
+	if err != nil || len(chain) != 2 || !chain[0].Equal(cert) || !chain[1].Equal(ca) {
+		t.Errorf("chain: %v certs, %v", len(chain), err)
+	}
+	key, err := readKey(s.certPath("web", "-key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(key.Public(), cert.PublicKey) {
+		t.Errorf("key doesn't match the cert")
+	}
+
+	// A client cert defaults to clientAuth and needs no SAN.
+	run(t, issueMain, "-dir", s.dir, "-type", "client", "-cn", "alice", "alice")
+	client := readCert(t, s.certPath("alice", ""))
+	if !reflect.DeepEqual(client.ExtKeyUsage, []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}) ||
+		len(client.DNSNames) != 0 || client.CheckSignatureFrom(ca) != nil {
+		t.Errorf("client: EKUs %v, DNS names %v", client.ExtKeyUsage, client.DNSNames)
+	}
+
+	if code := issueMain([]string{"-dir", s.dir, "web"}); code != 1 {
+		t.Errorf("issuing web again: exit %v, want 1", code)
+	}
+}
+
+func TestReissue(t *testing.T) {
+	s := newCA(t)
+	run(t, issueMain, "-dir", s.dir, "-san", "api.example.com", "api")
+	first := readCert(t, s.certPath("api", ""))
+
+	run(t, reissueMain, "-dir", s.dir, "api")
+	second := readCert(t, s.certPath("api", ""))
+	run(t, reissueMain, "-dir", s.dir, "-rekey", "api")
+	third := readCert(t, s.certPath("api", ""))
+
+	serials := map[string]bool{}
+	for _, cert := range []*x509.Certificate{first, second, third} {
+		serials[cert.SerialNumber.String()] = true
+		if !reflect.DeepEqual(cert.DNSNames, []string{"api.example.com"}) {
+			t.Errorf("serial %v: DNS names %v", cert.SerialNumber, cert.DNSNames)
+		}
+	}
+	if len(serials) != 3 {
+		t.Errorf("serial numbers reused: %v %v %v", first.SerialNumber, second.SerialNumber, third.SerialNumber)
+	}
+
+	// Only -rekey changes the key.
+	if !reflect.DeepEqual(first.PublicKey, second.PublicKey) {
+		t.Errorf("reissue changed the key")
+	}
+	if reflect.DeepEqual(second.PublicKey, third.PublicKey) {
+		t.Errorf("reissue -rekey kept the key")
+	}
+
+	s, err := openStore(s.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.state.Certs) != 3 || s.latest("api").Serial != third.SerialNumber.String() {
+		t.Errorf("recorded %v certs, latest %v", len(s.state.Certs), s.latest("api").Serial)
+	}
+}
@@ -0,0 +1,168 @@
+//
+// Copyright (C) 2017 Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/zentrope/tools/lib"
+)
+
+// defaultDir is where the CA lives unless -dir says otherwise.
+var defaultDir = envOr("CERTGEN_DIR", "ca")
+
+func envOr(name, value string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return value
+}
+
+func usage() {
+	fmt.Println("USAGE: certgen init [options]")
+	fmt.Println("       certgen issue [options] name")
+	fmt.Println("       certgen reissue [options] name")
+	fmt.Println("       certgen revoke [options] name|serial")
+	fmt.Println("       certgen crl [options]")
+	fmt.Println("       certgen list [options] [text|json]")
+	fmt.Println("")
+	fmt.Println("A local certificate authority for development and testing.")
+	fmt.Println("")
+	fmt.Println("Every command takes -dir (default $CERTGEN_DIR or ./ca) naming the")
+	fmt.Println("CA directory. Use 'certgen <command> -h' for a command's options.")
+}
+
+func initUsage(fs *flag.FlagSet) func() {
+	return func() {
+		fmt.Println("USAGE: certgen init [options]")
+		fmt.Println("")
+		fmt.Println("Create a root CA in a new directory.")
+		fmt.Println("")
+		fmt.Println("OPTIONS:")
+		fs.PrintDefaults()
+	}
+}
+
+func initMain(args []string) int {
+	var dir, cn, org, keyAlg, crlURL string
+	var bits, days int
+
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	fs.StringVar(&dir, "dir", defaultDir, "CA directory to create.")
+	fs.StringVar(&cn, "cn", "certgen Root CA", "Root common name.")
+	fs.StringVar(&org, "o", "", "Root organization.")
+	fs.StringVar(&keyAlg, "key", lib.KeyECDSA, "Key algorithm: rsa, ecdsa or ed25519.")
+	fs.IntVar(&bits, "bits", 0, "RSA modulus size (2048) or ECDSA curve (256, 384, 521).")
+	fs.IntVar(&days, "days", 3650, "Days the root is valid.")
+	fs.StringVar(&crlURL, "crl-url", "", "CRL distribution point to put in issued certs.")
+	fs.Usage = initUsage(fs)
+	fs.Parse(args)
+
+	s := &store{dir: dir, state: state{CRLURL: crlURL, Certs: make([]record, 0)}}
+
+	if _, err := os.Stat(s.path(stateFile)); err == nil {
+		fmt.Printf("ERROR: there's already a CA in '%v'\n", dir)
+		return 1
+	}
+
+	key, err := lib.GenerateKey(keyAlg, bits)
+	if err != nil {
+		fmt.Printf("ERROR: %v\n", err)
+		return 1
+	}
+
+	serial, err := randomSerial()
+	if err != nil {
+		fmt.Printf("ERROR: %v\n", err)
+		return 1
+	}
+
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.AddDate(0, 0, days),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	if org != "" {
+		tmpl.Subject.Organization = []string{org}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err == nil {
+		err = os.MkdirAll(dir, 0755)
+	}
+	if err == nil {
+		err = writeKey(s.path(caKeyFile), key)
+	}
+	if err == nil {
+		err = writePEM(s.path(caCertFile), "CERTIFICATE", der, 0644)
+	}
+	if err == nil {
+		s.cert, err = x509.ParseCertificate(der)
+		s.key = key
+	}
+	if err == nil {
+		err = s.writeCRL(7)
+	}
+	if err != nil {
+		fmt.Printf("ERROR: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Created %v, valid until %v\n", cn, tmpl.NotAfter.Format(time.RFC3339))
+	fmt.Printf("  cert: %v\n", s.path(caCertFile))
+	fmt.Printf("  key:  %v\n", s.path(caKeyFile))
+	return 0
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	commands := map[string]func([]string) int{
+		"init":    initMain,
+		"issue":   issueMain,
+		"reissue": reissueMain,
+		"revoke":  revokeMain,
+		"crl":     crlMain,
+		"list":    listMain,
+	}
+
+	command, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		if os.Args[1] == "help" || os.Args[1] == "-h" {
+			os.Exit(0)
+		}
+		os.Exit(2)
+	}
+
+	os.Exit(command(os.Args[2:]))
+}
@@ -0,0 +1,214 @@
+//
+// Copyright (C) 2017 Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/zentrope/tools/lib"
+)
+
+// writeCRL signs a new CRL listing every revoked cert, valid for days.
+func (s *store) writeCRL(days int) error {
+	now := time.Now()
+	s.state.CRLNumber++
+
+	tmpl := &x509.RevocationList{
+		Number:     big.NewInt(s.state.CRLNumber),
+		ThisUpdate: now,
+		NextUpdate: now.AddDate(0, 0, days),
+	}
+
+	for _, r := range s.state.Certs {
+		if !r.Revoked {
+			continue
+		}
+		serial, ok := new(big.Int).SetString(r.Serial, 10)
+		if !ok {
+			return fmt.Errorf("bad serial number '%v' in %v", r.Serial, stateFile)
+		}
+		tmpl.RevokedCertificateEntries = append(tmpl.RevokedCertificateEntries, x509.RevocationListEntry{
+			SerialNumber:   serial,
+			RevocationTime: r.RevokedAt,
+			ReasonCode:     r.Reason,
+		})
+	}
+
+	der, err := x509.CreateRevocationList(rand.Reader, tmpl, s.cert, s.key)
+	if err != nil {
+		return err
+	}
+	if err := writePEM(s.path(crlFile), "X509 CRL", der, 0644); err != nil {
+		return err
+	}
+	return s.save()
+}
+
+func revokeUsage(fs *flag.FlagSet) func() {
+	return func() {
+		fmt.Println("USAGE: certgen revoke [options] name|serial")
+		fmt.Println("")
+		fmt.Println("Revoke the current cert for name (or the cert with that serial")
+		fmt.Println("number) and write a new crl.pem.")
+		fmt.Println("")
+		fmt.Println("OPTIONS:")
+		fs.PrintDefaults()
+	}
+}
+
+func revokeMain(args []string) int {
+	var dir, reason string
+	var days int
+
+	fs := flag.NewFlagSet("revoke", flag.ExitOnError)
+	fs.StringVar(&dir, "dir", defaultDir, "CA directory.")
+	fs.StringVar(&reason, "reason", "unspecified", "CRL reason, e.g. keyCompromise or superseded.")
+	fs.IntVar(&days, "crl-days", 7, "Days until the CRL's next update.")
+	fs.Usage = revokeUsage(fs)
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	code, err := lib.ParseRevocationReason(reason)
+	if err != nil {
+		fmt.Printf("ERROR: %v\n", err)
+		return 1
+	}
+
+	s, err := openStore(dir)
+	if err != nil {
+		fmt.Printf("ERROR: %v\n", err)
+		return 1
+	}
+
+	r := s.find(fs.Arg(0))
+	if r == nil {
+		fmt.Printf("ERROR: no cert '%v'\n", fs.Arg(0))
+		return 1
+	}
+	if r.Revoked {
+		fmt.Printf("ERROR: %v (serial %v) is already revoked\n", r.Name, r.Serial)
+		return 1
+	}
+
+	r.Revoked = true
+	r.RevokedAt = time.Now().UTC()
+	r.Reason = code
+
+	if err := s.writeCRL(days); err != nil {
+		fmt.Printf("ERROR: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Revoked %v (serial %v), wrote %v\n", r.Name, r.Serial, s.path(crlFile))
+	return 0
+}
+
+func crlMain(args []string) int {
+	var dir string
+	var days int
+
+	fs := flag.NewFlagSet("crl", flag.ExitOnError)
+	fs.StringVar(&dir, "dir", defaultDir, "CA directory.")
+	fs.IntVar(&days, "crl-days", 7, "Days until the CRL's next update.")
+	fs.Usage = func() {
+		fmt.Println("USAGE: certgen crl [options]")
+		fmt.Println("")
+		fmt.Println("Write a fresh crl.pem (e.g. before the last one's next update).")
+		fmt.Println("")
+		fmt.Println("OPTIONS:")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	s, err := openStore(dir)
+	if err == nil {
+		err = s.writeCRL(days)
+	}
+	if err != nil {
+		fmt.Printf("ERROR: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Wrote %v (number %v)\n", s.path(crlFile), s.state.CRLNumber)
+	return 0
+}
+
+func listMain(args []string) int {
+	var dir string
+
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	fs.StringVar(&dir, "dir", defaultDir, "CA directory.")
+	fs.Usage = func() {
+		fmt.Println("USAGE: certgen list [options] [text|json]")
+		fmt.Println("")
+		fmt.Println("List every cert issued, oldest first.")
+		fmt.Println("")
+		fmt.Println("OPTIONS:")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	s, err := openStore(dir)
+	if err != nil {
+		fmt.Printf("ERROR: %v\n", err)
+		return 1
+	}
+
+	switch fs.Arg(0) {
+
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(s.state.Certs); err != nil {
+			fmt.Printf("ERROR: %v\n", err)
+			return 1
+		}
+
+	case "", "text":
+		now := time.Now()
+		for _, r := range s.state.Certs {
+			status := "valid"
+			switch {
+			case r.Revoked:
+				status = "revoked"
+			case now.After(r.NotAfter):
+				status = "expired"
+			case s.latest(r.Name) != nil && s.latest(r.Name).Serial != r.Serial:
+				status = "replaced"
+			}
+			fmt.Printf("%-20v %-8v %-6v %-8v %v %v\n", r.Name, status, r.Profile.Type,
+				r.Profile.Key, r.NotAfter.Format("2006-01-02"), r.Serial)
+		}
+
+	default:
+		fs.Usage()
+		return 2
+	}
+
+	return 0
+}
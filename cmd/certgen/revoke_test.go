@@ -0,0 +1,96 @@
+//
+// Copyright (C) 2017 Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"testing"
+)
+
+func readCRL(t *testing.T, s *store) *x509.RevocationList {
+	t.Helper()
+	data, err := ioutil.ReadFile(s.path(crlFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "X509 CRL" {
+		t.Fatalf("%v: no CRL", s.path(crlFile))
+	}
+	crl, err := x509.ParseRevocationList(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := crl.CheckSignatureFrom(readCert(t, s.path(caCertFile))); err != nil {
+		t.Fatal(err)
+	}
+	return crl
+}
+
+// revoked returns the revoked serial numbers in crl, to their reasons.
+func revoked(crl *x509.RevocationList) map[string]int {
+	serials := make(map[string]int)
+	for _, entry := range crl.RevokedCertificateEntries {
+		serials[entry.SerialNumber.String()] = entry.ReasonCode
+	}
+	return serials
+}
+
+func TestRevoke(t *testing.T) {
+	s := newCA(t)
+
+	// init writes an empty CRL.
+	if crl := readCRL(t, s); crl.Number.Int64() != 1 || len(crl.RevokedCertificateEntries) != 0 {
+		t.Errorf("initial CRL %v lists %v certs", crl.Number, len(crl.RevokedCertificateEntries))
+	}
+
+	run(t, issueMain, "-dir", s.dir, "web")
+	run(t, issueMain, "-dir", s.dir, "api")
+	web := readCert(t, s.certPath("web", "")).SerialNumber.String()
+	api := readCert(t, s.certPath("api", "")).SerialNumber.String()
+
+	run(t, revokeMain, "-dir", s.dir, "-reason", "keyCompromise", "web")
+	crl := readCRL(t, s)
+	if got := revoked(crl); len(got) != 1 || got[web] != 1 || crl.Number.Int64() != 2 {
+		t.Errorf("CRL %v: got %v, want just %v (reason 1)", crl.Number, got, web)
+	}
+
+	if code := revokeMain([]string{"-dir", s.dir, "web"}); code != 1 {
+		t.Errorf("revoking web again: exit %v, want 1", code)
+	}
+
+	// Once revoked, the name can be issued again, with a new serial.
+	run(t, issueMain, "-dir", s.dir, "web")
+	reissued := readCert(t, s.certPath("web", "")).SerialNumber.String()
+	if reissued == web {
+		t.Errorf("reissued web with revoked serial %v", web)
+	}
+
+	// By serial number rather than name.
+	run(t, revokeMain, "-dir", s.dir, api)
+	run(t, crlMain, "-dir", s.dir)
+	crl = readCRL(t, s)
+	want := map[string]int{web: 1, api: 0}
+	if got := revoked(crl); len(got) != 2 || got[web] != 1 || got[api] != 0 || crl.Number.Int64() != 4 {
+		t.Errorf("CRL %v: got %v, want %v", crl.Number, got, want)
+	}
+	if _, ok := revoked(crl)[reissued]; ok {
+		t.Errorf("CRL lists the reissued cert %v", reissued)
+	}
+}
@@ -0,0 +1,180 @@
+//
+// Copyright (C) 2017 Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/zentrope/tools/lib"
+)
+
+// The CA directory looks like:
+//
+//	ca.pem               the root cert
+//	ca-key.pem           its key
+//	ca.json              settings and the record of every cert issued
+//	crl.pem              the latest CRL
+//	certs/NAME.pem       the current cert for NAME
+//	certs/NAME-key.pem   its key
+//	certs/NAME-chain.pem the cert followed by the root
+const (
+	caCertFile = "ca.pem"
+	caKeyFile  = "ca-key.pem"
+	stateFile  = "ca.json"
+	crlFile    = "crl.pem"
+	certsDir   = "certs"
+)
+
+// record is a cert the CA has issued.
+type record struct {
+	Name      string    `json:"name"`
+	Serial    string    `json:"serial"`
+	Profile   profile   `json:"profile"`
+	NotBefore time.Time `json:"notBefore"`
+	NotAfter  time.Time `json:"notAfter"`
+	Revoked   bool      `json:"revoked,omitempty"`
+	RevokedAt time.Time `json:"revokedAt,omitzero"`
+	Reason    int       `json:"reason,omitempty"`
+}
+
+// state is everything in ca.json.
+type state struct {
+	CRLNumber int64    `json:"crlNumber"`
+	CRLURL    string   `json:"crlUrl,omitempty"`
+	Certs     []record `json:"certs"`
+}
+
+// store is a CA directory.
+type store struct {
+	dir   string
+	state state
+	cert  *x509.Certificate
+	key   crypto.Signer
+}
+
+func (s *store) path(parts ...string) string {
+	return filepath.Join(append([]string{s.dir}, parts...)...)
+}
+
+func (s *store) certPath(name, suffix string) string {
+	return s.path(certsDir, name+suffix+".pem")
+}
+
+// openStore loads the CA in dir.
+func openStore(dir string) (*store, error) {
+	s := &store{dir: dir}
+
+	data, err := ioutil.ReadFile(s.path(stateFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no CA in '%v' (run certgen init first)", dir)
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.state); err != nil {
+		return nil, fmt.Errorf("%v: %v", s.path(stateFile), err)
+	}
+
+	data, err = ioutil.ReadFile(s.path(caCertFile))
+	if err != nil {
+		return nil, err
+	}
+	certs, err := lib.ParseCertificates(data, "")
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", s.path(caCertFile), err)
+	}
+	s.cert = certs[0]
+
+	data, err = ioutil.ReadFile(s.path(caKeyFile))
+	if err != nil {
+		return nil, err
+	}
+	if s.key, err = lib.ParsePrivateKey(data, ""); err != nil {
+		return nil, fmt.Errorf("%v: %v", s.path(caKeyFile), err)
+	}
+
+	return s, nil
+}
+
+// save writes ca.json.
+func (s *store) save() error {
+	data, err := json.MarshalIndent(s.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.path(stateFile), append(data, '\n'), 0644)
+}
+
+// latest returns the most recently issued cert for name.
+func (s *store) latest(name string) *record {
+	for i := len(s.state.Certs) - 1; i >= 0; i-- {
+		if s.state.Certs[i].Name == name {
+			return &s.state.Certs[i]
+		}
+	}
+	return nil
+}
+
+// find returns the current cert for a name, or the cert with a serial
+// number.
+func (s *store) find(nameOrSerial string) *record {
+	if r := s.latest(nameOrSerial); r != nil {
+		return r
+	}
+	for i := range s.state.Certs {
+		if s.state.Certs[i].Serial == nameOrSerial {
+			return &s.state.Certs[i]
+		}
+	}
+	return nil
+}
+
+func checkName(name string) error {
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		return fmt.Errorf("bad cert name '%v'", name)
+	}
+	return nil
+}
+
+func writePEM(path, blockType string, der []byte, mode os.FileMode) error {
+	return ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), mode)
+}
+
+func writeKey(path string, key crypto.Signer) error {
+	data, err := lib.EncodePrivateKey(key)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+func readKey(path string) (crypto.Signer, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return lib.ParsePrivateKey(data, "")
+}
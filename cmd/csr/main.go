@@ -0,0 +1,383 @@
+//
+// Copyright (C) 2017 Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/zentrope/tools/lib"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// subject is the subject section of a config file. The names match
+// the JSON for a certificate's subject.
+type subject struct {
+	CommonName         string   `json:"commonName,omitempty"`
+	Country            []string `json:"country,omitempty"`
+	Organization       []string `json:"organization,omitempty"`
+	OrganizationalUnit []string `json:"organizationalUnit,omitempty"`
+	Locality           []string `json:"locality,omitempty"`
+	Province           []string `json:"province,omitempty"`
+	StreetAddress      []string `json:"streetAddress,omitempty"`
+	PostalCode         []string `json:"postalCode,omitempty"`
+}
+
+// config is what goes in a request, from a JSON file and/or flags.
+type config struct {
+	Subject subject  `json:"subject"`
+	SANs    []string `json:"sans,omitempty"`
+	Key     string   `json:"key"`
+	Bits    int      `json:"bits,omitempty"`
+}
+
+func (s subject) name() pkix.Name {
+	return pkix.Name{
+		CommonName:         s.CommonName,
+		Country:            s.Country,
+		Organization:       s.Organization,
+		OrganizationalUnit: s.OrganizationalUnit,
+		Locality:           s.Locality,
+		Province:           s.Province,
+		StreetAddress:      s.StreetAddress,
+		PostalCode:         s.PostalCode,
+	}
+}
+
+func splitList(value string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// checkName makes sure name, which may have come from a common name or
+// SAN, is usable as the start of a file name in this directory.
+func checkName(name string) error {
+	if strings.ContainsAny(name, `/\*:`) || strings.HasPrefix(name, ".") {
+		return fmt.Errorf("can't name files after '%v', give a name after the options", name)
+	}
+	return nil
+}
+
+// promptPassword asks for the password for path, if there's someone
+// at the terminal to ask.
+func promptPassword(path string) (string, bool) {
+	if !terminal.IsTerminal(int(os.Stdin.Fd())) {
+		return "", false
+	}
+	fmt.Fprintf(os.Stderr, "Password for %v: ", path)
+	password, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", false
+	}
+	return string(password), true
+}
+
+// readKey reads the key in path, asking for its password if needed.
+func readKey(path, password string) (crypto.Signer, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := lib.ParsePrivateKey(data, password)
+	if err == lib.ErrPasswordRequired && password == "" {
+		if typed, ok := promptPassword(path); ok {
+			key, err = lib.ParsePrivateKey(data, typed)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	return key, nil
+}
+
+// writeKey writes key to path, which mustn't already exist.
+func writeKey(path string, key crypto.Signer) error {
+	data, err := lib.EncodePrivateKey(key)
+	if err != nil {
+		return err
+	}
+	return writeNew(path, data, 0600)
+}
+
+// writeNew writes data to path, which mustn't already exist.
+func writeNew(path string, data []byte, mode os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if os.IsExist(err) {
+		return fmt.Errorf("%v already exists, not overwriting it", path)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	return f.Close()
+}
+
+func usage() {
+	fmt.Println("USAGE: csr new [options] [name]")
+	fmt.Println("       csr show file [text|json]")
+	fmt.Println("")
+	fmt.Println("Create certificate signing requests, or show what's in them.")
+	fmt.Println("")
+	fmt.Println("'new' writes name.csr and name-key.pem (name defaults to the common")
+	fmt.Println("name). Use 'csr new -h' for its options.")
+	fmt.Println("")
+	fmt.Println("'show' prints each request in a PEM or DER file with the same keys")
+	fmt.Println("(prefixed 'csr.') as sslq uses for certificates (prefixed 'cert.').")
+}
+
+//-----------------------------------------------------------------------------
+
+func newMain(args []string) int {
+	var configFile, cn, org, ou, country, province, locality, sans, keyAlg, keyFile, password string
+	var bits int
+
+	fs := flag.NewFlagSet("new", flag.ExitOnError)
+	fs.StringVar(&configFile, "config", "", "JSON config to start from (flags override it).")
+	fs.StringVar(&cn, "cn", "", "Subject common name.")
+	fs.StringVar(&org, "o", "", "Subject organization.")
+	fs.StringVar(&ou, "ou", "", "Subject organizational unit.")
+	fs.StringVar(&country, "c", "", "Subject country.")
+	fs.StringVar(&province, "st", "", "Subject state or province.")
+	fs.StringVar(&locality, "l", "", "Subject locality.")
+	fs.StringVar(&sans, "san", "", "Comma separated DNS names, IPs, emails or URIs.")
+	fs.StringVar(&keyAlg, "key", lib.KeyRSA, "Key algorithm: rsa, ecdsa or ed25519.")
+	fs.IntVar(&bits, "bits", 0, "RSA modulus size (2048) or ECDSA curve (256, 384, 521).")
+	fs.StringVar(&keyFile, "keyfile", "", "Sign with this existing key rather than a new one.")
+	fs.StringVar(&password, "password", "", "Password for the -keyfile key (prompted for if needed).")
+	fs.Usage = func() {
+		fmt.Println("USAGE: csr new [options] [name]")
+		fmt.Println("")
+		fmt.Println("OPTIONS:")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	cfg := config{Key: lib.KeyRSA}
+	if configFile != "" {
+		data, err := ioutil.ReadFile(configFile)
+		if err == nil {
+			err = json.Unmarshal(data, &cfg)
+		}
+		if err != nil {
+			fmt.Printf("ERROR: %v: %v\n", configFile, err)
+			return 1
+		}
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "cn":
+			cfg.Subject.CommonName = cn
+		case "o":
+			cfg.Subject.Organization = []string{org}
+		case "ou":
+			cfg.Subject.OrganizationalUnit = []string{ou}
+		case "c":
+			cfg.Subject.Country = []string{country}
+		case "st":
+			cfg.Subject.Province = []string{province}
+		case "l":
+			cfg.Subject.Locality = []string{locality}
+		case "san":
+			cfg.SANs = splitList(sans)
+		case "key":
+			cfg.Key = keyAlg
+		case "bits":
+			cfg.Bits = bits
+		}
+	})
+
+	name := fs.Arg(0)
+	if name == "" {
+		name = cfg.Subject.CommonName
+	}
+	if name == "" && len(cfg.SANs) > 0 {
+		name = cfg.SANs[0]
+	}
+	if name == "" {
+		fmt.Println("ERROR: give a name, a common name or a SAN")
+		return 2
+	}
+	if err := checkName(name); err != nil {
+		fmt.Printf("ERROR: %v\n", err)
+		return 2
+	}
+
+	alt, err := lib.ParseAltNames(cfg.SANs)
+	if err != nil {
+		fmt.Printf("ERROR: %v\n", err)
+		return 1
+	}
+
+	var key crypto.Signer
+	if keyFile != "" {
+		key, err = readKey(keyFile, password)
+	} else {
+		key, err = lib.GenerateKey(cfg.Key, cfg.Bits)
+	}
+	if err != nil {
+		fmt.Printf("ERROR: %v\n", err)
+		return 1
+	}
+
+	tmpl := &x509.CertificateRequest{
+		Subject:        cfg.Subject.name(),
+		DNSNames:       alt.DNSNames,
+		EmailAddresses: alt.EmailAddresses,
+		IPAddresses:    alt.IPAddresses,
+		URIs:           alt.URIs,
+	}
+
+	der, err := x509.CreateCertificateRequest(rand.Reader, tmpl, key)
+	if err != nil {
+		fmt.Printf("ERROR: %v\n", err)
+		return 1
+	}
+
+	// Check for an old request before writing a key that would go
+	// with it. The key goes first so a request is never written for a
+	// key that couldn't be.
+	csrFile := name + ".csr"
+	if _, err := os.Stat(csrFile); err == nil {
+		fmt.Printf("ERROR: %v already exists, not overwriting it\n", csrFile)
+		return 1
+	}
+	if keyFile == "" {
+		keyOut := name + "-key.pem"
+		if err := writeKey(keyOut, key); err != nil {
+			fmt.Printf("ERROR: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Wrote %v\n", keyOut)
+	}
+
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+	if err := writeNew(csrFile, data, 0644); err != nil {
+		fmt.Printf("ERROR: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Wrote %v\n", csrFile)
+
+	return 0
+}
+
+//-----------------------------------------------------------------------------
+
+func showMain(args []string) int {
+	if len(args) < 1 || len(args) > 2 {
+		usage()
+		return 2
+	}
+
+	format := "text"
+	if len(args) == 2 {
+		format = args[1]
+	}
+
+	data, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		fmt.Printf("ERROR: %v\n", err)
+		return 1
+	}
+
+	csrs, err := lib.ParseCertificateRequests(data)
+	if err != nil {
+		fmt.Printf("ERROR: %v: %v\n", args[0], err)
+		return 1
+	}
+
+	described := make([]*lib.CertificateRequest, 0, len(csrs))
+	for _, csr := range csrs {
+		described = append(described, lib.NewCertificateRequest(csr))
+	}
+
+	switch format {
+
+	case "json":
+		doc := struct {
+			Requests []*lib.CertificateRequest `json:"requests"`
+		}{described}
+
+		buf := new(bytes.Buffer)
+		enc := json.NewEncoder(buf)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(doc); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%v", buf.String())
+
+	case "text":
+		for i, r := range described {
+			if i > 0 {
+				fmt.Println()
+			}
+			props := r.Properties()
+			for _, k := range props.Keys() {
+				fmt.Printf("%-35v = %v\n", k, props.Get(k))
+			}
+		}
+
+	default:
+		fmt.Printf("ERROR: Unrecognized output format: '%v'.\n", format)
+		return 2
+	}
+
+	for _, r := range described {
+		if !r.SignatureValid {
+			return 1
+		}
+	}
+	return 0
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "new":
+			os.Exit(newMain(os.Args[2:]))
+		case "show":
+			os.Exit(showMain(os.Args[2:]))
+		}
+	}
+
+	usage()
+	if len(os.Args) > 1 && os.Args[1] == "help" {
+		os.Exit(0)
+	}
+	os.Exit(2)
+}
@@ -0,0 +1,148 @@
+//
+// Copyright (C) 2017 Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// sampleLines is the most lines read ahead to detect a grammar, enough
+// for the sample records in any of the built-in grammars.
+const sampleLines = 2000
+
+// grammarScore is how cleanly a grammar parses the sample records.
+type grammarScore struct {
+	Name    string
+	Grammar *grammer
+	Score   float64 // 0 to 1
+	Records int
+	Parsed  int     // without error
+	Fields  float64 // per parsed record
+}
+
+// score rates how well r parses each record: not at all if there's an
+// error, otherwise by the fraction of its required fields present and
+// whether the timestamp (if it has one) parses.
+func (r *grammer) score(records []string) grammarScore {
+	s := grammarScore{Name: r.Name, Grammar: r, Records: len(records)}
+
+	total, fields := 0.0, 0
+	for _, record := range records {
+		env, err := r.parse(r.prepare(record))
+		if err != nil {
+			continue
+		}
+		s.Parsed++
+		fields += len(env)
+
+		points, out := 1.0, 1.0
+		if len(r.Required) > 0 {
+			points -= float64(len(r.missing(env))) / float64(len(r.Required))
+		}
+		if r.Timestamp != nil {
+			out++
+			if _, err := r.Timestamp.parse(env, nil); err == nil {
+				points++
+			}
+		}
+		total += points / out
+	}
+
+	if s.Records > 0 {
+		s.Score = total / float64(s.Records)
+	}
+	if s.Parsed > 0 {
+		s.Fields = float64(fields) / float64(s.Parsed)
+	}
+	return s
+}
+
+// sampleInput reads ahead in input, returning the lines read and a
+// reader to use in its place which starts with them again.
+func sampleInput(input io.Reader) ([]byte, *bufio.Reader) {
+	reader := bufio.NewReader(input)
+	var sample bytes.Buffer
+	for i := 0; i < sampleLines; i++ {
+		line, err := reader.ReadString('\n')
+		sample.WriteString(line)
+		if err != nil {
+			break
+		}
+	}
+	replay := io.MultiReader(bytes.NewReader(sample.Bytes()), reader)
+	return sample.Bytes(), bufio.NewReader(replay)
+}
+
+// sampleRecords returns the first n records of the sample as r would
+// split them.
+func (r *grammer) sampleRecords(sample []byte, n int) []string {
+	records := make([]string, 0, n)
+	r.records(bufio.NewReader(bytes.NewReader(sample)), func(record string) {
+		if len(records) < n {
+			records = append(records, record)
+		}
+	})
+	return records
+}
+
+// rankGrammars scores each built-in grammar against the first n
+// records of the sample, best first.
+func rankGrammars(sample []byte, n int) ([]grammarScore, error) {
+	scores := make([]grammarScore, 0)
+	for _, name := range builtinNames() {
+		g, err := builtinGrammer(name)
+		if err != nil {
+			return nil, err
+		}
+		s := g.score(g.sampleRecords(sample, n))
+		s.Name = name
+		scores = append(scores, s)
+	}
+
+	// Ties go to the grammar pulling out more fields.
+	sort.SliceStable(scores, func(i, j int) bool {
+		if scores[i].Score != scores[j].Score {
+			return scores[i].Score > scores[j].Score
+		}
+		return scores[i].Fields > scores[j].Fields
+	})
+	return scores, nil
+}
+
+// detectGrammar returns the best fitting built-in grammar.
+func detectGrammar(sample []byte, n int) (*grammer, error) {
+	scores, err := rankGrammars(sample, n)
+	if err != nil {
+		return nil, err
+	}
+	if len(scores) == 0 || scores[0].Score == 0 {
+		return nil, errors.New("none of the grammars fit (use -detect to see how they score)")
+	}
+	return scores[0].Grammar, nil
+}
+
+func printRanking(w io.Writer, scores []grammarScore) {
+	for _, s := range scores {
+		fmt.Fprintf(w, "%-10v %5.1f%%  %v of %v records parsed, %.1f fields each\n",
+			s.Name, s.Score*100, s.Parsed, s.Records, s.Fields)
+	}
+}
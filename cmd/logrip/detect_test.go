@@ -0,0 +1,139 @@
+//
+// Copyright (C) 2017 Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"testing"
+)
+
+// scoreGrammar needs ts and both a and b, separated by single spaces.
+const scoreGrammar = `{
+  "name": "score",
+  "required": ["a", "b"],
+  "timestamp": { "fields": ["ts"], "layouts": ["2006-01-02T15:04:05Z07:00"], "zone": "UTC" },
+  "numberOfFields": 3,
+  "delimiter": " ",
+  "fields": [ { "name": "ts" }, { "name": "a" }, { "name": "b" } ]
+}`
+
+func TestScore(t *testing.T) {
+	g, err := parseGrammer([]byte(scoreGrammar))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		records []string
+		score   float64
+		parsed  int
+		fields  float64
+	}{
+		{"everything", []string{"2017-06-01T12:00:00Z x y"}, 1, 1, 3},
+		{"half the required fields", []string{"2017-06-01T12:00:00Z  y"}, 0.75, 1, 3},
+		{"no required fields", []string{"2017-06-01T12:00:00Z  "}, 0.5, 1, 3},
+		{"bad timestamp", []string{"yesterday x y"}, 0.5, 1, 3},
+		{"nothing", []string{"yesterday  "}, 0, 1, 3},
+		{"parse error", []string{"x"}, 0, 0, 0},
+		{"averaged over all records",
+			[]string{"2017-06-01T12:00:00Z x y", "2017-06-01T12:00:00Z  y", "yesterday x y", "x"},
+			(1 + 0.75 + 0.5 + 0) / 4, 3, 3},
+		{"no records", []string{}, 0, 0, 0},
+	}
+
+	for _, test := range tests {
+		s := g.score(test.records)
+		if s.Score != test.score || s.Parsed != test.parsed || s.Fields != test.fields {
+			t.Errorf("%v: got score %v, %v parsed, %v fields, want %v, %v, %v", test.name,
+				s.Score, s.Parsed, s.Fields, test.score, test.parsed, test.fields)
+		}
+		if s.Records != len(test.records) {
+			t.Errorf("%v: got %v records, want %v", test.name, s.Records, len(test.records))
+		}
+	}
+
+	// Without required fields or a timestamp, parsing is all that counts.
+	g.Required, g.Timestamp = nil, nil
+	if s := g.score([]string{"yesterday  ", "x"}); s.Score != 0.5 {
+		t.Errorf("no requirements: got %v, want 0.5", s.Score)
+	}
+}
+
+func TestRankGrammars(t *testing.T) {
+	tests := []struct {
+		name   string
+		sample string
+		want   string
+	}{
+		{"syslog", "Dec 31 23:59:58 mbp com.apple.xpc.launchd[1] (com.apple.foo[123]): Service exited\n" +
+			"\twith abnormal code: 1\n" +
+			"Jan  1 00:00:01 mbp sshd[4242]: Accepted publickey for keith\n", "syslog"},
+		{"rfc5424", "<34>1 2003-10-11T22:14:15.003Z mymachine.example.com su - ID47 - 'su root' failed\n" +
+			"<165>1 2003-10-11T22:14:15.003000-07:00 host.example.com evntslog 1234 ID47 " +
+			"[exampleSDID@32473 iut=\"3\" eventSource=\"Application\"] An application event\n", "rfc5424"},
+		{"journal", "__REALTIME_TIMESTAMP=1342540861416351\n_PID=1\n_COMM=systemd\n_HOSTNAME=waldi\n" +
+			"PRIORITY=6\nMESSAGE=Starting Network Manager...\n\n" +
+			"__REALTIME_TIMESTAMP=1342540861421465\n_PID=747\n_COMM=dbus-daemon\n_HOSTNAME=waldi\n" +
+			"PRIORITY=5\nMESSAGE=Successfully activated service\n", "journal"},
+		{"combined", `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326 ` +
+			`"http://www.example.com/start.html" "Mozilla/4.08 [en] (Win98; I ;Nav)"` + "\n", "combined"},
+		{"golog", "2009/11/10 23:00:00 Hello, log file!\n" +
+			"2018/01/02 10:00:00.123456 main.go:42: with microseconds and file\n", "golog"},
+		{"logfmt", `ts=2018-01-02T10:00:00Z level=info msg="request done" path=/api status=200` + "\n" +
+			`time="2018-01-02T10:00:01Z" level=error msg="bad \"thing\" happened" err="EOF"` + "\n", "logfmt"},
+	}
+
+	for _, test := range tests {
+		scores, err := rankGrammars([]byte(test.sample), 20)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(scores) != len(builtinNames()) {
+			t.Errorf("%v: %v grammars ranked, want %v", test.name, len(scores), len(builtinNames()))
+			continue
+		}
+
+		best := scores[0]
+		if best.Name != test.want || best.Score != 1 {
+			t.Errorf("%v: best is %v at %v, want %v at 1", test.name, best.Name, best.Score, test.want)
+		}
+		if next := scores[1]; next.Score >= best.Score {
+			t.Errorf("%v: %v ties with %v at %v", test.name, next.Name, best.Name, next.Score)
+		}
+
+		for i := 1; i < len(scores); i++ {
+			a, b := scores[i-1], scores[i]
+			if a.Score < b.Score || (a.Score == b.Score && a.Fields < b.Fields) {
+				t.Errorf("%v: %v (%v, %v fields) ranked above %v (%v, %v fields)", test.name,
+					a.Name, a.Score, a.Fields, b.Name, b.Score, b.Fields)
+			}
+		}
+
+		g, err := detectGrammar([]byte(test.sample), 20)
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+		} else if g.Name != best.Grammar.Name {
+			t.Errorf("%v: detected %v, want %v", test.name, g.Name, best.Grammar.Name)
+		}
+	}
+
+	for _, sample := range []string{"", "!!!\n???\n"} {
+		if g, err := detectGrammar([]byte(sample), 20); err == nil {
+			t.Errorf("%q: detected %v, want none", sample, g.Name)
+		}
+	}
+}
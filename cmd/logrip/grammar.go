@@ -0,0 +1,449 @@
+//
+// Copyright (C) 2017 Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Grammars compiled into the binary, named for their files.
+//
+//go:embed grammar/*.json
+var builtinGrammars embed.FS
+
+// A field is split into sub-fields on its delimiter, matched against a
+// pattern whose named groups become fields (and are split further by
+// sub-fields of the same name), or read as key=value pairs. Its regular
+// expressions are compiled when the grammar is loaded, after which it
+// doesn't change.
+type field struct {
+	Name           string  `json:"name"`
+	Delimiter      string  `json:"delimiter"`
+	Scrub          string  `json:"scrub"`
+	NumberOfFields int     `json:"numberOfFields"`
+	Fields         []field `json:"fields"`
+	Pattern        string  `json:"pattern"`
+	Pairs          bool    `json:"pairs"`
+	Separator      string  `json:"separator"`
+	Quoted         bool    `json:"quoted"`
+
+	delimiterRE *regexp.Regexp
+	scrubRE     *regexp.Regexp
+	patternRE   *regexp.Regexp
+}
+
+type grammer struct {
+	Name               string            `json:"name"`
+	Description        string            `json:"description"`
+	OS                 string            `json:"os"`
+	Delimiter          string            `json:"delimiter"`
+	CondenseWhitespace bool              `json:"condenseWhitespace"`
+	CheckContinuations bool              `json:"checkContinuations"`
+	BlankLineSeparated bool              `json:"blankLineSeparated"`
+	NumberOfFields     int               `json:"numberOfFields"`
+	Fields             []field           `json:"fields"`
+	Required           []string          `json:"required"`
+	Timestamp          *timestampSpec    `json:"timestamp"`
+	Translation        map[string]string `json:"translation"`
+	NilValue           string            `json:"nilValue"`
+
+	delimiterRE *regexp.Regexp
+}
+
+func parseGrammer(content []byte) (*grammer, error) {
+	var grammer grammer
+	err := json.Unmarshal(content, &grammer)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := grammer.compile(); err != nil {
+		return nil, err
+	}
+
+	return &grammer, nil
+}
+
+// compile checks the grammar and compiles its regular expressions, so
+// that a bad one is reported when the grammar is loaded rather than
+// when (and every time) a record is parsed.
+func (r *grammer) compile() error {
+	if len(r.Fields) == 0 {
+		return fmt.Errorf("grammar has no fields")
+	}
+
+	var err error
+	if r.delimiterRE, err = compileRE("delimiter", r.Delimiter); err != nil {
+		return err
+	}
+
+	for i := range r.Fields {
+		if err := r.Fields[i].compile(""); err != nil {
+			return err
+		}
+	}
+
+	if r.Timestamp != nil {
+		return r.Timestamp.load()
+	}
+	return nil
+}
+
+// compile compiles the regular expressions the field and its
+// sub-fields use, naming them from the path of their parent fields.
+func (r *field) compile(parent string) error {
+	path := r.Name
+	if parent != "" {
+		path = parent + "." + r.Name
+	}
+
+	var err error
+	switch {
+	case r.Pattern != "":
+		if r.patternRE, err = compileRE("pattern", r.Pattern); err != nil {
+			break
+		}
+		groups := make(map[string]bool)
+		for _, name := range r.patternRE.SubexpNames() {
+			if name != "" {
+				groups[name] = true
+			}
+		}
+		if len(groups) == 0 {
+			err = fmt.Errorf("pattern has no named groups")
+		}
+		for _, sub := range r.Fields {
+			if !groups[sub.Name] {
+				err = fmt.Errorf("pattern has no group for sub-field %v", sub.Name)
+			}
+		}
+	case r.Pairs:
+		delimiter := r.Delimiter
+		if delimiter == "" {
+			delimiter = `\s+`
+		}
+		if !r.Quoted {
+			r.delimiterRE, err = compileRE("delimiter", delimiter)
+		}
+	case len(r.Fields) > 0:
+		if r.delimiterRE, err = compileRE("delimiter", r.Delimiter); err != nil {
+			break
+		}
+		if r.Scrub != "" {
+			r.scrubRE, err = compileRE("scrub", r.Scrub)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("field %v: %v", path, err)
+	}
+
+	for i := range r.Fields {
+		if err := r.Fields[i].compile(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func compileRE(what, expr string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", what, err)
+	}
+	return re, nil
+}
+
+func newGrammer(filename string) (*grammer, error) {
+
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	grammer, err := parseGrammer(content)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", filename, err)
+	}
+
+	return grammer, nil
+}
+
+// builtinNames returns the names of the built-in grammars, sorted.
+func builtinNames() []string {
+	entries, _ := builtinGrammars.ReadDir("grammar")
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, strings.TrimSuffix(entry.Name(), ".json"))
+	}
+	sort.Strings(names)
+	return names
+}
+
+func builtinGrammer(name string) (*grammer, error) {
+	content, err := builtinGrammars.ReadFile(path.Join("grammar", name+".json"))
+	if err != nil {
+		return nil, fmt.Errorf("no grammar '%v' (built-in: %v)", name,
+			strings.Join(builtinNames(), ", "))
+	}
+	return parseGrammer(content)
+}
+
+// loadGrammer reads the grammar file at name, or if there's no such
+// file, the built-in grammar called name.
+func loadGrammer(name string) (*grammer, error) {
+	if _, err := os.Stat(name); err == nil {
+		return newGrammer(name)
+	}
+	return builtinGrammer(name)
+}
+
+// All logs assume a continuation is a line starting with whitespace
+var continuationRE = regexp.MustCompile(`^\s`)
+
+// All logs might want to condense whitespace: each of the characters
+// matching \s becomes a space, without the cost of a regular expression.
+func condense(record string) string {
+	return strings.Map(func(c rune) rune {
+		switch c {
+		case '\t', '\n', '\f', '\r':
+			return ' '
+		}
+		return c
+	}, record)
+}
+
+type fieldMap map[string]string
+
+// lookup returns the value of the first of the names, separated by
+// "|", with one.
+func (env fieldMap) lookup(names string) string {
+	for _, name := range strings.Split(names, "|") {
+		if value := env[name]; value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// parse sets the field, or its sub-fields, from line. Sub-fields
+// beyond the tokens in line are left unset, but a pattern that doesn't
+// match or pairs without a separator are errors.
+func (r field) parse(env fieldMap, line string) error {
+
+	if r.Pattern != "" {
+		return r.parsePattern(env, line)
+	}
+
+	if r.Pairs {
+		return r.parsePairs(env, line)
+	}
+
+	if len(r.Fields) == 0 {
+		env[r.Name] = line
+		return nil
+	}
+
+	line2 := line
+	if r.scrubRE != nil {
+		line2 = r.scrubRE.ReplaceAllLiteralString(line2, "")
+	}
+
+	tokens := r.delimiterRE.Split(line2, len(r.Fields))
+
+	for i, field := range r.Fields {
+		if i < len(tokens) {
+			if err := field.parse(env, tokens[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// parsePattern sets a field for each named group matched, parsing it
+// further with the sub-field of the same name, if any.
+func (r field) parsePattern(env fieldMap, line string) error {
+	match := r.patternRE.FindStringSubmatch(line)
+	if match == nil {
+		return fmt.Errorf("%v doesn't match its pattern", r.Name)
+	}
+
+	for i, name := range r.patternRE.SubexpNames() {
+		if name == "" || match[i] == "" {
+			continue
+		}
+		env[name] = match[i]
+		for _, field := range r.Fields {
+			if field.Name == name {
+				if err := field.parse(env, match[i]); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// parsePairs sets a field for each key=value pair, split on the
+// delimiter (or whitespace) and the separator (or "="). Quoted pairs
+// are logfmt style: values may be in double quotes, with \ escapes,
+// and are only split on whitespace.
+func (r field) parsePairs(env fieldMap, line string) error {
+	separator := r.Separator
+	if separator == "" {
+		separator = "="
+	}
+
+	var tokens []string
+	if r.Quoted {
+		tokens = splitQuoted(line)
+	} else {
+		tokens = r.delimiterRE.Split(line, -1)
+	}
+
+	pairs := 0
+	for _, token := range tokens {
+		if token == "" {
+			continue
+		}
+		key, value := token, ""
+		if i := strings.Index(token, separator); i != -1 {
+			key, value = token[:i], token[i+len(separator):]
+			pairs++
+		}
+		if r.Quoted {
+			value = unquote(value)
+		}
+		env[key] = value
+	}
+
+	if pairs == 0 {
+		return fmt.Errorf("%v has no %v pairs", r.Name, separator)
+	}
+	return nil
+}
+
+// splitQuoted splits line on whitespace outside of double quotes.
+func splitQuoted(line string) []string {
+	tokens := make([]string, 0)
+	var token strings.Builder
+	quoted, escaped := false, false
+
+	for _, c := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\' && quoted:
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case !quoted && (c == ' ' || c == '\t'):
+			if token.Len() > 0 {
+				tokens = append(tokens, token.String())
+				token.Reset()
+			}
+			continue
+		}
+		token.WriteRune(c)
+	}
+
+	if token.Len() > 0 {
+		tokens = append(tokens, token.String())
+	}
+	return tokens
+}
+
+// unquote removes the double quotes and escapes from a logfmt value.
+func unquote(value string) string {
+	if len(value) < 2 || value[0] != '"' || value[len(value)-1] != '"' {
+		return value
+	}
+	var out strings.Builder
+	escaped := false
+	for _, c := range value[1 : len(value)-1] {
+		if c == '\\' && !escaped {
+			escaped = true
+			continue
+		}
+		if escaped {
+			switch c {
+			case 'n':
+				c = '\n'
+			case 't':
+				c = '\t'
+			}
+			escaped = false
+		}
+		out.WriteRune(c)
+	}
+	return out.String()
+}
+
+func (r *grammer) parse(line string) (fieldMap, error) {
+	tokens := r.delimiterRE.Split(line, r.NumberOfFields)
+
+	if len(tokens) < len(r.Fields) {
+		return nil, fmt.Errorf("mismatched tokens/fields: %v tokens split on `%v`, %v fields",
+			len(tokens), r.Delimiter, len(r.Fields))
+	}
+
+	env := make(fieldMap, 0)
+	for i, field := range r.Fields {
+		if err := field.parse(env, tokens[i]); err != nil {
+			return env, err
+		}
+	}
+
+	return env, nil
+}
+
+// missing returns the required fields with no value.
+func (r *grammer) missing(env fieldMap) []string {
+	missing := make([]string, 0)
+	for _, name := range r.Required {
+		if env.lookup(name) == "" {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// prepare trims a record, condensing whitespace if the grammar says
+// to.
+func (r *grammer) prepare(record string) string {
+	record = strings.TrimRight(record, "\r\n")
+	if r.CondenseWhitespace {
+		record = condense(record)
+		record = strings.TrimSpace(record)
+	}
+	return record
+}
+
+func (r *grammer) isContinuation(line string) bool {
+	if r.CheckContinuations {
+		return continuationRE.MatchString(line)
+	}
+	return false
+}
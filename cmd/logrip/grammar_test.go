@@ -0,0 +1,266 @@
+//
+// Copyright (C) 2017 Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitQuoted(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"", []string{}},
+		{"a=1 b=2", []string{"a=1", "b=2"}},
+		{"  a=1 \t b=2  ", []string{"a=1", "b=2"}},
+		{`msg="hello world" level=info`, []string{`msg="hello world"`, "level=info"}},
+		{`msg="say \"hi there\"" x=1`, []string{`msg="say \"hi there\""`, "x=1"}},
+		{`path="C:\\Program Files\\" x=1`, []string{`path="C:\\Program Files\\"`, "x=1"}},
+		{`a\ b=1`, []string{`a\`, "b=1"}},
+		{`msg="unterminated x=1`, []string{`msg="unterminated x=1`}},
+		{`empty="" x=1`, []string{`empty=""`, "x=1"}},
+	}
+
+	for _, test := range tests {
+		if got := splitQuoted(test.line); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("splitQuoted(%q): got %q, want %q", test.line, got, test.want)
+		}
+	}
+}
+
+func TestUnquote(t *testing.T) {
+	tests := []struct {
+		value, want string
+	}{
+		{"plain", "plain"},
+		{`""`, ""},
+		{`"hello world"`, "hello world"},
+		{`"say \"hi\""`, `say "hi"`},
+		{`"back\\slash"`, `back\slash`},
+		{`"two\nlines"`, "two\nlines"},
+		{`"a\tb"`, "a\tb"},
+		{`"\x"`, "x"},
+		{`"`, `"`},
+		{`"half`, `"half`},
+		{`half"`, `half"`},
+		{`"\\"`, `\`},
+	}
+
+	for _, test := range tests {
+		if got := unquote(test.value); got != test.want {
+			t.Errorf("unquote(%q): got %q, want %q", test.value, got, test.want)
+		}
+	}
+}
+
+func TestParsePairs(t *testing.T) {
+	tests := []struct {
+		name    string
+		field   field
+		line    string
+		want    fieldMap
+		wantErr bool
+	}{
+		{"whitespace", field{Name: "kv", Pairs: true},
+			"a=1  b=2\tc=", fieldMap{"a": "1", "b": "2", "c": ""}, false},
+		{"bare keys", field{Name: "kv", Pairs: true},
+			"a=1 flag", fieldMap{"a": "1", "flag": ""}, false},
+		{"value with the separator", field{Name: "kv", Pairs: true},
+			"q=a=b", fieldMap{"q": "a=b"}, false},
+		{"delimiter and separator", field{Name: "kv", Pairs: true, Delimiter: `;\s*`, Separator: ": "},
+			"host: web-1; user: alice smith", fieldMap{"host": "web-1", "user": "alice smith"}, false},
+		{"unquoted keeps quotes", field{Name: "kv", Pairs: true},
+			`a="1"`, fieldMap{"a": `"1"`}, false},
+		{"quoted", field{Name: "kv", Pairs: true, Quoted: true},
+			`level=info msg="user \"alice\" logged in" path="C:\\tmp" n=3`,
+			fieldMap{"level": "info", "msg": `user "alice" logged in`, "path": `C:\tmp`, "n": "3"}, false},
+		{"quoted escapes", field{Name: "kv", Pairs: true, Quoted: true},
+			`err="line one\nline two\tend"`, fieldMap{"err": "line one\nline two\tend"}, false},
+		{"no pairs", field{Name: "kv", Pairs: true},
+			"just some words", fieldMap{"just": "", "some": "", "words": ""}, true},
+		{"empty", field{Name: "kv", Pairs: true, Quoted: true},
+			"", fieldMap{}, true},
+	}
+
+	for _, test := range tests {
+		if err := test.field.compile(""); err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		env := make(fieldMap)
+		err := test.field.parse(env, test.line)
+		switch {
+		case test.wantErr && err == nil:
+			t.Errorf("%v: no error, want one", test.name)
+		case !test.wantErr && err != nil:
+			t.Errorf("%v: %v", test.name, err)
+		}
+		if !reflect.DeepEqual(env, test.want) {
+			t.Errorf("%v: got %v, want %v", test.name, env, test.want)
+		}
+	}
+}
+
+func TestParseLogfmt(t *testing.T) {
+	g, err := builtinGrammer("logfmt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := `ts=2017-06-01T12:00:00Z level=warn app=api msg="slow \"GET /\" request" ms=1503`
+	env, err := g.parse(g.prepare(line))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := fieldMap{"ts": "2017-06-01T12:00:00Z", "level": "warn", "app": "api",
+		"msg": `slow "GET /" request`, "ms": "1503"}
+	if !reflect.DeepEqual(env, want) {
+		t.Errorf("got %v, want %v", env, want)
+	}
+	if missing := g.missing(env); len(missing) != 0 {
+		t.Errorf("missing %v", strings.Join(missing, ", "))
+	}
+}
+
+func TestParseGrammerErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		grammar string
+		wantErr string
+	}{
+		{"bad JSON", `{"name": `, "unexpected end of JSON input"},
+		{"no fields", `{"name": "x"}`, "grammar has no fields"},
+		{"delimiter", `{"delimiter": "[", "fields": [{"name": "a"}]}`,
+			"delimiter: error parsing regexp"},
+		{"field delimiter", `{"delimiter": " ", "fields": [
+			{"name": "outer", "delimiter": "(", "fields": [{"name": "a"}]}]}`,
+			"field outer: delimiter: error parsing regexp"},
+		{"scrub", `{"delimiter": " ", "fields": [
+			{"name": "outer", "delimiter": " ", "scrub": "a**", "fields": [{"name": "a"}]}]}`,
+			"field outer: scrub: error parsing regexp"},
+		{"nested pattern", `{"delimiter": " ", "fields": [
+			{"name": "outer", "delimiter": " ", "fields": [
+				{"name": "inner", "pattern": "(?P<x>[a-"}]}]}`,
+			"field outer.inner: pattern: error parsing regexp"},
+		{"pairs delimiter", `{"delimiter": "\\n", "fields": [
+			{"name": "line", "delimiter": " ", "fields": [
+				{"name": "kv", "pairs": true, "delimiter": "[;"}]}]}`,
+			"field line.kv: delimiter: error parsing regexp"},
+		{"no named groups", `{"delimiter": " ", "fields": [{"name": "line", "pattern": "(.*)"}]}`,
+			"field line: pattern has no named groups"},
+		{"no group for sub-field", `{"delimiter": " ", "fields": [
+			{"name": "line", "pattern": "(?P<a>.*)", "fields": [{"name": "b", "delimiter": " "}]}]}`,
+			"field line: pattern has no group for sub-field b"},
+		{"timestamp layouts", `{"delimiter": " ", "fields": [{"name": "a"}],
+			"timestamp": {"fields": ["a"]}}`, "timestamp needs fields and layouts"},
+		{"timestamp zone", `{"delimiter": " ", "fields": [{"name": "a"}],
+			"timestamp": {"fields": ["a"], "layouts": ["unix"], "zone": "Mars/Olympus_Mons"}}`,
+			"timestamp: unknown time zone Mars/Olympus_Mons"},
+	}
+
+	for _, test := range tests {
+		_, err := parseGrammer([]byte(test.grammar))
+		if err == nil {
+			t.Errorf("%v: no error, want one about %q", test.name, test.wantErr)
+		} else if !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("%v: error %q, want one about %q", test.name, err, test.wantErr)
+		}
+	}
+
+	for _, name := range builtinNames() {
+		if _, err := builtinGrammer(name); err != nil {
+			t.Errorf("built-in %v: %v", name, err)
+		}
+	}
+}
+
+//-----------------------------------------------------------------------------
+// Benchmarks
+//-----------------------------------------------------------------------------
+
+var benchHosts = []string{"mbp", "web-1", "web-2", "db.internal"}
+
+var benchPrograms = []string{"kernel", "sshd", "com.apple.xpc.launchd", "cron", "postfix/smtpd", "nginx"}
+
+var benchMessages = []string{
+	"Accepted publickey for keith from 10.0.0.7 port 53122 ssh2: RSA SHA256:x0Zr4",
+	"Service exited with abnormal code: 1",
+	"(root) CMD (run-parts /etc/cron.hourly)",
+	"connect from unknown[192.168.1.20]",
+	"upstream timed out (110: Connection timed out) while reading response header",
+	"IOThunderboltSwitch<0>(0x0)::listenerCallback - Thunderbolt HPD packet for route = 0x0 port = 11 unplug = 0",
+}
+
+// syntheticSyslog returns n lines of syslog, a few seconds apart and
+// running over the end of a year, with the odd continuation line.
+func syntheticSyslog(n int) []byte {
+	random := rand.New(rand.NewSource(1))
+	at := time.Date(2016, time.December, 31, 12, 0, 0, 0, time.Local)
+
+	var b bytes.Buffer
+	for i := 0; i < n; i++ {
+		at = at.Add(time.Duration(random.Intn(3000)) * time.Millisecond)
+		program := benchPrograms[random.Intn(len(benchPrograms))]
+		fmt.Fprintf(&b, "%v %v %v[%v]: %v\n", at.Format(time.Stamp),
+			benchHosts[random.Intn(len(benchHosts))], program, 100+random.Intn(60000),
+			benchMessages[random.Intn(len(benchMessages))])
+		if random.Intn(50) == 0 {
+			i++
+			b.WriteString("\tcontinued on the next line\n")
+		}
+	}
+	return b.Bytes()
+}
+
+// BenchmarkRip rips synthetic syslog with the syslog grammar, writing
+// the facts nowhere in each output format.
+func BenchmarkRip(b *testing.B) {
+	const lines = 10000
+	data := syntheticSyslog(lines)
+
+	r, err := builtinGrammer("syslog")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, format := range outputFormats {
+		b.Run(format, func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				w, err := newFactWriter(format, io.Discard)
+				if err != nil {
+					b.Fatal(err)
+				}
+				tl := &timeline{ref: time.Date(2017, time.January, 2, 0, 0, 0, 0, time.Local)}
+				if err = r.rip(bufio.NewReader(bytes.NewReader(data)), w, tl); err == nil {
+					err = w.flush()
+				}
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(lines*b.N)/b.Elapsed().Seconds(), "lines/sec")
+		})
+	}
+}
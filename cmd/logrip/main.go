@@ -18,186 +18,220 @@ package main
 
 import (
 	"bufio"
-	"encoding/json"
+	"flag"
 	"fmt"
-	"io/ioutil"
-	"log"
+	"io"
 	"os"
-	"regexp"
 	"strings"
+	"time"
 )
 
-type field struct {
-	Name           string  `json:"name"`
-	Delimiter      string  `json:"delimiter"`
-	Scrub          string  `json:"scrub"`
-	NumberOfFields int     `json:"numberOfFields"`
-	Fields         []field `json:"fields"`
-}
-
-type grammer struct {
-	Name               string  `json:"name"`
-	OS                 string  `json:"os"`
-	Delimiter          string  `json:"delimiter"`
-	CondenseWhitespace bool    `json:"condenseWhitespace"`
-	CheckContinuations bool    `json:"checkContinuations"`
-	NumberOfFields     int     `json:"numberOfFields"`
-	Fields             []field `json:"fields"`
+// rip parses each record in input, writing the facts made of those
+// that fit the grammar to out and warning about those that don't.
+func (r *grammer) rip(input *bufio.Reader, out factWriter, tl *timeline) error {
+	var err error
+	r.records(input, func(record string) {
+		if err != nil {
+			return
+		}
+		line := r.prepare(record)
+		env, perr := r.parse(line)
+		if perr != nil {
+			fmt.Fprintf(os.Stderr, "WARNING: %v: %v\n", perr, line)
+			return
+		}
+		err = out.write(line, r.newLogFact(env, tl))
+	})
+	return err
 }
 
-func newGrammer(filename string) (*grammer, error) {
-
-	content, err := ioutil.ReadFile(filename)
-	if err != nil {
-		return nil, err
-	}
+// records reads records from input, joining continuation lines (or,
+// for blank line separated grammars, every line up to a blank one),
+// and passes each to emit.
+func (r *grammer) records(input *bufio.Reader, emit func(string)) {
+	var bigLine string
 
-	var grammer grammer
-	err = json.Unmarshal(content, &grammer)
-	if err != nil {
-		return nil, err
+	flush := func() {
+		if strings.TrimSpace(bigLine) != "" {
+			emit(bigLine)
+		}
+		bigLine = ""
 	}
 
-	return &grammer, nil
-}
+	for {
+		line, err := input.ReadString('\n')
 
-type logFact struct {
-	Month       string
-	Day         string
-	Hour        string
-	Minute      string
-	Second      string
-	ProcessID   string
-	ProcessName string
-	Message     string
-}
+		switch {
+		case line == "":
+		case strings.Index(line, "--- last message repeated") != -1:
+			// skip these for now
+		case r.BlankLineSeparated:
+			if strings.TrimSpace(line) == "" {
+				flush()
+			} else {
+				bigLine = bigLine + line
+			}
+		case !r.isContinuation(line):
+			flush()
+			bigLine = line
+		default:
+			bigLine = bigLine + " " + strings.TrimSpace(line)
+		}
 
-func newLogFact(env fieldMap) logFact {
-	return logFact{
-		Month:       env["month"],
-		Day:         env["day"],
-		Hour:        env["hour"],
-		Minute:      env["minute"],
-		Second:      env["second"],
-		ProcessID:   env["process-id"],
-		ProcessName: env["process-name"],
-		Message:     env["message"],
+		if err != nil {
+			flush()
+			break
+		}
 	}
 }
 
-// All logs assume a continuation is a line starting with whitespace
-var continuationRE = regexp.MustCompile(`^\s`)
-
-// All logs might want to condense whitespace.
-var condenseRE = regexp.MustCompile(`\s`)
-
-type fieldMap map[string]string
-
-func (r field) parse(env fieldMap, line string) {
-
-	if len(r.Fields) == 0 {
-		env[r.Name] = line
-		return
-	}
-
-	line2 := line
-	tokensRE := regexp.MustCompile(r.Delimiter)
-
-	if r.Scrub != "" {
-		scrubRE := regexp.MustCompile(r.Scrub)
-		line2 = scrubRE.ReplaceAllLiteralString(line2, "")
-	}
+//-----------------------------------------------------------------------------
+// MAIN
+//-----------------------------------------------------------------------------
 
-	tokens := tokensRE.Split(line2, len(r.Fields))
+func usage() {
+	fmt.Println("USAGE: logrip [options] [file.log...]")
+	fmt.Println("")
+	fmt.Println("Parse log records read from the files (or stdin) using a grammar,")
+	fmt.Println("either a JSON grammar file or one of the built-in grammars (see")
+	fmt.Println("-list-grammars). By default the built-in grammar that best parses")
+	fmt.Println("the first records of each file is used; -detect shows how each of")
+	fmt.Println("them scores.")
+	fmt.Println("")
+	fmt.Println("Timestamps without a year are placed in the year up to the file's")
+	fmt.Println("modification time (or now, for a pipe), or with -year, counted on")
+	fmt.Println("from the year of the first record.")
+	fmt.Println("")
+	fmt.Println("OPTIONS:")
+	flag.PrintDefaults()
+}
 
-	for i, field := range r.Fields {
-		if i < len(tokens) {
-			field.parse(env, tokens[i])
+func listGrammars(out io.Writer) {
+	for _, name := range builtinNames() {
+		g, err := builtinGrammer(name)
+		if err != nil {
+			fmt.Fprintf(out, "%-10v ERROR: %v\n", name, err)
+			continue
 		}
+		fmt.Fprintf(out, "%-10v %v\n", name, g.Description)
 	}
 }
 
-func (r *grammer) parse(line string) fieldMap {
-	tokensRE := regexp.MustCompile(r.Delimiter)
-	tokens := tokensRE.Split(line, r.NumberOfFields)
-
-	if len(tokens) < len(r.Fields) {
-		log.Fatal(fmt.Sprintf("mismatched tokens/fields: `%v`[%#v]", r.Delimiter, tokens))
+// openInput opens the named file, or stdin for "-", returning it with
+// its modification time (or now, if it's not a regular file) as the
+// reference for year inference.
+func openInput(name string) (*os.File, time.Time, error) {
+	file := os.Stdin
+	if name != "-" {
+		var err error
+		if file, err = os.Open(name); err != nil {
+			return nil, time.Time{}, err
+		}
 	}
 
-	env := make(fieldMap, 0)
-	for i, field := range r.Fields {
-		field.parse(env, tokens[i])
+	ref := time.Now()
+	if info, err := file.Stat(); err == nil && info.Mode().IsRegular() {
+		ref = info.ModTime()
 	}
-
-	return env
+	return file, ref, nil
 }
 
-func (r *grammer) isContinuation(line string) bool {
-	if r.CheckContinuations {
-		return continuationRE.MatchString(line)
-	}
-	return false
-}
-
-func (r *grammer) doOutput(line string) {
-	if line == "" {
+func main() {
+	var grammarName, output, tz string
+	var list, detect bool
+	var sample, year int
+
+	flag.StringVar(&grammarName, "grammar", "auto", "Grammar file, the name of a built-in grammar, or auto to detect it.")
+	flag.BoolVar(&list, "list-grammars", false, "List the built-in grammars and exit.")
+	flag.BoolVar(&detect, "detect", false, "Rank the built-in grammars against the input and exit.")
+	flag.IntVar(&sample, "sample", 20, "Records to sample when detecting the grammar.")
+	flag.StringVar(&output, "output", "text", "Output format: text, jsonl, csv or logfmt.")
+	flag.IntVar(&year, "year", 0, "Year of the first record, for timestamps without one.")
+	flag.StringVar(&tz, "tz", "", "Time zone of timestamps without an offset, overriding the grammar's.")
+	flag.Usage = usage
+	flag.Parse()
+
+	if list {
+		listGrammars(os.Stdout)
 		return
 	}
 
-	line2 := line
-	if r.CondenseWhitespace {
-		line2 = condenseRE.ReplaceAllString(line2, " ")
-		line2 = strings.TrimSpace(line2)
+	stdout := bufio.NewWriter(os.Stdout)
+	out, err := newFactWriter(output, stdout)
+	if err != nil {
+		fmt.Printf("ERROR: %v\n", err)
+		os.Exit(2)
 	}
 
-	fmt.Printf("\n%s\n", line2)
-
-	result := r.parse(line2)
-	fmt.Println("fact:")
-	for k, v := range result {
-		fmt.Printf("  %-8v: %v\n", k, v)
+	var zone *time.Location
+	if tz != "" {
+		if zone, err = loadZone(tz); err != nil {
+			fmt.Printf("ERROR: %v\n", err)
+			os.Exit(2)
+		}
 	}
-	//fmt.Printf("fact:\n  prelude: %v\n  message: %v\n", result["prelude"], result["message"])
-	// fmt.Printf("%#v\n", r.parse(line2)["prelude"])
-
-	//	fmt.Printf("%#v\n", newLogFact(r.parse(line2)))
-}
-
-//-----------------------------------------------------------------------------
-// MAIN
-//-----------------------------------------------------------------------------
-
-func main() {
-	fmt.Println("Log Ripper")
 
-	rules, err := newGrammer("grammar/syslog.json")
-	if err != nil {
-		log.Fatal(err)
+	var rules *grammer
+	if grammarName != "auto" && !detect {
+		if rules, err = loadGrammer(grammarName); err != nil {
+			fmt.Printf("ERROR: %v\n", err)
+			os.Exit(1)
+		}
 	}
 
-	input := bufio.NewReader(os.Stdin)
+	names := flag.Args()
+	if len(names) == 0 {
+		names = []string{"-"}
+	}
 
-	var bigLine string
+	status := 0
+	for _, name := range names {
+		file, ref, err := openInput(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			status = 1
+			continue
+		}
 
-	for {
-		line, err := input.ReadString('\n')
+		input := bufio.NewReader(file)
+		r := rules
+
+		switch {
+		case detect:
+			data, _ := sampleInput(input)
+			var scores []grammarScore
+			if scores, err = rankGrammars(data, sample); err == nil {
+				if len(names) > 1 {
+					fmt.Fprintf(stdout, "%v:\n", name)
+				}
+				printRanking(stdout, scores)
+			}
+		case r == nil:
+			var data []byte
+			data, input = sampleInput(input)
+			if r, err = detectGrammar(data, sample); err != nil {
+				err = fmt.Errorf("%v: %v", name, err)
+			}
+		}
 
-		// skip these for now
-		if strings.Index(line, "--- last message repeated") != -1 {
-			continue
+		if err == nil && r != nil {
+			err = r.rip(input, out, &timeline{zone: zone, year: year, ref: ref})
 		}
+		file.Close()
 
 		if err != nil {
-			rules.doOutput(bigLine)
-			break
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			status = 1
 		}
+	}
 
-		if !rules.isContinuation(line) {
-			rules.doOutput(bigLine)
-			bigLine = line
-		} else {
-			bigLine = bigLine + " " + strings.TrimSpace(line)
-		}
+	err = out.flush()
+	if err == nil {
+		err = stdout.Flush()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
 	}
+	os.Exit(status)
 }
@@ -0,0 +1,249 @@
+//
+// Copyright (C) 2017 Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// logFact is the canonical record, whatever the log's format.
+type logFact struct {
+	Timestamp string            `json:"timestamp"`
+	Host      string            `json:"host"`
+	Process   string            `json:"process"`
+	PID       string            `json:"pid"`
+	Severity  string            `json:"severity"`
+	Message   string            `json:"message"`
+	Extra     map[string]string `json:"extra,omitempty"`
+}
+
+// canonicalFields are the logFact fields a grammar's translation may
+// map its own fields onto.
+var canonicalFields = []string{"timestamp", "host", "process", "pid", "severity", "message"}
+
+// severityNames are the syslog severities, by number.
+var severityNames = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// severityName names a numeric syslog severity or priority (whose low
+// three bits are the severity), leaving anything else as it is.
+func severityName(value string) string {
+	if n, err := strconv.Atoi(value); err == nil && n >= 0 {
+		return severityNames[n%8]
+	}
+	return value
+}
+
+// newLogFact fills in a fact from the parsed fields using the
+// grammar's translation, with the timestamp in RFC 3339 format from
+// its timestamp spec, placed on the timeline. If the time doesn't
+// parse, the timestamp is left as it appears in the record. Fields not
+// used go in Extra.
+func (r *grammer) newLogFact(env fieldMap, tl *timeline) *logFact {
+	used := make(map[string]bool)
+
+	value := func(canonical string) string {
+		names, ok := r.Translation[canonical]
+		if !ok {
+			return ""
+		}
+		for _, name := range strings.Split(names, "|") {
+			used[name] = true
+		}
+		v := env.lookup(names)
+		if v == r.NilValue {
+			return ""
+		}
+		return v
+	}
+
+	fact := &logFact{
+		Timestamp: value("timestamp"),
+		Host:      value("host"),
+		Process:   value("process"),
+		PID:       value("pid"),
+		Severity:  severityName(value("severity")),
+		Message:   value("message"),
+		Extra:     make(map[string]string),
+	}
+
+	if r.Timestamp != nil {
+		if ts, err := tl.timestamp(r.Timestamp, env); err == nil {
+			fact.Timestamp = ts
+		} else if fact.Timestamp == "" {
+			fact.Timestamp = r.Timestamp.raw(env)
+		}
+		for _, names := range r.Timestamp.Fields {
+			for _, name := range strings.Split(names, "|") {
+				used[name] = true
+			}
+		}
+	}
+
+	for k, v := range env {
+		if !used[k] && v != r.NilValue {
+			fact.Extra[k] = v
+		}
+	}
+
+	return fact
+}
+
+// values returns the fact's canonical fields in canonicalFields order.
+func (f *logFact) values() []string {
+	return []string{f.Timestamp, f.Host, f.Process, f.PID, f.Severity, f.Message}
+}
+
+func (f *logFact) extraKeys() []string {
+	keys := make([]string, 0, len(f.Extra))
+	for k := range f.Extra {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+//-----------------------------------------------------------------------------
+// Writers
+//-----------------------------------------------------------------------------
+
+// A factWriter writes facts in one of the output formats.
+type factWriter interface {
+	write(record string, fact *logFact) error
+	flush() error
+}
+
+var outputFormats = []string{"text", "jsonl", "csv", "logfmt"}
+
+func newFactWriter(format string, w io.Writer) (factWriter, error) {
+	switch format {
+	case "text":
+		return &textWriter{w}, nil
+	case "jsonl":
+		return &jsonlWriter{json.NewEncoder(w)}, nil
+	case "csv":
+		return &csvWriter{w: csv.NewWriter(w)}, nil
+	case "logfmt":
+		return &logfmtWriter{w}, nil
+	}
+	return nil, fmt.Errorf("unknown output format '%v' (use %v)", format,
+		strings.Join(outputFormats, ", "))
+}
+
+// textWriter shows each record with the fact made of it, for working
+// on grammars.
+type textWriter struct {
+	w io.Writer
+}
+
+func (t *textWriter) write(record string, fact *logFact) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "\n%s\n", record)
+	fmt.Fprintln(&b, "fact:")
+	for i, v := range fact.values() {
+		fmt.Fprintf(&b, "  %-10v: %v\n", canonicalFields[i], v)
+	}
+	for _, k := range fact.extraKeys() {
+		fmt.Fprintf(&b, "  %-10v: %v\n", k, fact.Extra[k])
+	}
+	_, err := io.WriteString(t.w, b.String())
+	return err
+}
+
+func (t *textWriter) flush() error {
+	return nil
+}
+
+// jsonlWriter writes a JSON object per line.
+type jsonlWriter struct {
+	enc *json.Encoder
+}
+
+func (j *jsonlWriter) write(record string, fact *logFact) error {
+	return j.enc.Encode(fact)
+}
+
+func (j *jsonlWriter) flush() error {
+	return nil
+}
+
+// csvWriter writes a header and then a row per fact, with the extra
+// fields (which vary from record to record) in one logfmt column.
+type csvWriter struct {
+	w      *csv.Writer
+	header bool
+}
+
+func (c *csvWriter) write(record string, fact *logFact) error {
+	if !c.header {
+		c.header = true
+		header := append(append([]string{}, canonicalFields...), "extra")
+		if err := c.w.Write(header); err != nil {
+			return err
+		}
+	}
+
+	extra := make([]string, 0, len(fact.Extra))
+	for _, k := range fact.extraKeys() {
+		extra = append(extra, logfmtPair(k, fact.Extra[k]))
+	}
+	return c.w.Write(append(fact.values(), strings.Join(extra, " ")))
+}
+
+func (c *csvWriter) flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// logfmtWriter writes a line of key=value pairs per fact, canonical
+// fields first, leaving out those with no value.
+type logfmtWriter struct {
+	w io.Writer
+}
+
+func (l *logfmtWriter) write(record string, fact *logFact) error {
+	pairs := make([]string, 0)
+	for i, v := range fact.values() {
+		if v != "" {
+			pairs = append(pairs, logfmtPair(canonicalFields[i], v))
+		}
+	}
+	for _, k := range fact.extraKeys() {
+		pairs = append(pairs, logfmtPair(k, fact.Extra[k]))
+	}
+	_, err := fmt.Fprintln(l.w, strings.Join(pairs, " "))
+	return err
+}
+
+func (l *logfmtWriter) flush() error {
+	return nil
+}
+
+// logfmtPair formats key=value, quoting the value if it's empty or
+// has spaces, quotes, equals signs or control characters in it.
+func logfmtPair(key, value string) string {
+	if value == "" || strings.ContainsAny(value, " =\"\\") ||
+		strings.IndexFunc(value, func(c rune) bool { return c < ' ' || c == 0x7f }) != -1 {
+		value = strconv.Quote(value)
+	}
+	return key + "=" + value
+}
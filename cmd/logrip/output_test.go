@@ -0,0 +1,106 @@
+//
+// Copyright (C) 2017 Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSeverityName(t *testing.T) {
+	tests := []struct {
+		value, want string
+	}{
+		{"0", "emerg"},
+		{"3", "err"},
+		{"7", "debug"},
+		{"34", "crit"},
+		{"165", "notice"},
+		{"warn", "warn"},
+		{"-1", "-1"},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		if got := severityName(test.value); got != test.want {
+			t.Errorf("severityName(%q): got %q, want %q", test.value, got, test.want)
+		}
+	}
+}
+
+func TestNewLogFact(t *testing.T) {
+	tests := []struct {
+		grammar string
+		record  string
+		want    logFact
+	}{
+		{"syslog", "Dec 31 23:59:58 mbp com.apple.xpc.launchd[1] (com.apple.foo[123]): Service exited\n" +
+			"\twith abnormal code: 1",
+			logFact{Timestamp: "2017-12-31T23:59:58Z", Host: "mbp", Process: "com.apple.xpc.launchd", PID: "1",
+				Message: "Service exited  with abnormal code: 1",
+				Extra:   map[string]string{"procetc": "(com.apple.foo[123])"}}},
+		{"syslog", "Jan  1 00:00:01 mbp sshd: Accepted publickey for keith",
+			logFact{Timestamp: "2017-01-01T00:00:01Z", Host: "mbp", Process: "sshd",
+				Message: "Accepted publickey for keith", Extra: map[string]string{}}},
+		{"rfc5424", "<34>1 2003-10-11T22:14:15.003Z mymachine.example.com su - ID47 - 'su root' failed",
+			logFact{Timestamp: "2003-10-11T22:14:15.003Z", Host: "mymachine.example.com", Process: "su",
+				Severity: "crit", Message: "'su root' failed",
+				Extra: map[string]string{"msgid": "ID47", "version": "1"}}},
+		{"rfc5424", `<165>1 2003-10-11T22:14:15.003-07:00 - evntslog 1234 - [id@1 a="b"] An event`,
+			logFact{Timestamp: "2003-10-11T22:14:15.003-07:00", Process: "evntslog", PID: "1234",
+				Severity: "notice", Message: "An event",
+				Extra: map[string]string{"structured": `[id@1 a="b"]`, "version": "1"}}},
+		{"journal", "__REALTIME_TIMESTAMP=1342540861421465\n_PID=747\n_COMM=dbus-daemon\n" +
+			"_HOSTNAME=waldi\nPRIORITY=5\nMESSAGE=Activated\n_UID=81",
+			logFact{Timestamp: "2012-07-17T16:01:01.421465Z", Host: "waldi", Process: "dbus-daemon", PID: "747",
+				Severity: "notice", Message: "Activated", Extra: map[string]string{"_UID": "81"}}},
+		{"journal", "__REALTIME_TIMESTAMP=1342540861421465\n_PID=1\nSYSLOG_PID=2\n_COMM=systemd\n" +
+			"SYSLOG_IDENTIFIER=init\nMESSAGE=Hello",
+			logFact{Timestamp: "2012-07-17T16:01:01.421465Z", Process: "init", PID: "1",
+				Message: "Hello", Extra: map[string]string{}}},
+		{"combined", `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /a.gif HTTP/1.0" 200 2326 "-" "curl/7.58.0"`,
+			logFact{Timestamp: "2000-10-10T13:55:36-07:00", Message: "GET /a.gif HTTP/1.0",
+				Extra: map[string]string{"remote": "127.0.0.1", "user": "frank", "method": "GET", "path": "/a.gif",
+					"protocol": "HTTP/1.0", "status": "200", "bytes": "2326", "agent": "curl/7.58.0"}}},
+		{"golog", "2018/01/02 10:00:00.123456 main.go:42: with microseconds and file",
+			logFact{Timestamp: "2018-01-02T10:00:00.123456Z", Message: "with microseconds and file",
+				Extra: map[string]string{"file": "main.go", "lineno": "42"}}},
+		{"logfmt", `time="2018-01-02T10:00:01+01:00" lvl=error hostname=web-1 service=api msg="it broke" err=EOF flag`,
+			logFact{Timestamp: "2018-01-02T10:00:01+01:00", Host: "web-1", Process: "api", Severity: "error",
+				Message: "it broke", Extra: map[string]string{"err": "EOF"}}},
+		{"logfmt", `ts=yesterday msg=hi`,
+			logFact{Timestamp: "yesterday", Message: "hi", Extra: map[string]string{}}},
+	}
+
+	for _, test := range tests {
+		g, err := builtinGrammer(test.grammar)
+		if err != nil {
+			t.Fatal(err)
+		}
+		env, err := g.parse(g.prepare(test.record))
+		if err != nil {
+			t.Errorf("%v %q: %v", test.grammar, test.record, err)
+			continue
+		}
+
+		tl := &timeline{zone: time.UTC, year: 2017}
+		if got := g.newLogFact(env, tl); !reflect.DeepEqual(*got, test.want) {
+			t.Errorf("%v %q:\n got %+v\nwant %+v", test.grammar, test.record, *got, test.want)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+//
+// Copyright (C) 2017 Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timestampSpec says which fields, joined with spaces, make up a
+// record's time, the layouts (as for time.Parse, or "unix",
+// "unixmilli" or "unixmicro") it may be in, and the zone (an IANA
+// name, "UTC" or "Local", the default) of times without an offset.
+type timestampSpec struct {
+	Fields  []string `json:"fields"`
+	Layouts []string `json:"layouts"`
+	Zone    string   `json:"zone"`
+
+	location *time.Location
+}
+
+// load checks the spec and looks up its zone.
+func (t *timestampSpec) load() error {
+	if len(t.Fields) == 0 || len(t.Layouts) == 0 {
+		return fmt.Errorf("timestamp needs fields and layouts")
+	}
+	location, err := loadZone(t.Zone)
+	if err != nil {
+		return fmt.Errorf("timestamp: %v", err)
+	}
+	t.location = location
+	return nil
+}
+
+// loadZone looks up a time zone by name, taking "" to be local time.
+func loadZone(name string) (*time.Location, error) {
+	if name == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(name)
+}
+
+// raw returns the spec's fields as they appear in the record.
+func (t *timestampSpec) raw(env fieldMap) string {
+	values := make([]string, 0, len(t.Fields))
+	for _, name := range t.Fields {
+		if value := env.lookup(name); value != "" {
+			values = append(values, value)
+		}
+	}
+	return strings.Join(values, " ")
+}
+
+// parse returns the time from the spec's fields, in zone if it's not
+// nil, otherwise the spec's. Times whose layout has no year are in
+// year 0 (see timeline).
+func (t *timestampSpec) parse(env fieldMap, zone *time.Location) (time.Time, error) {
+	values := make([]string, 0, len(t.Fields))
+	for _, name := range t.Fields {
+		value := env.lookup(name)
+		if value == "" {
+			return time.Time{}, fmt.Errorf("no %v for the timestamp", name)
+		}
+		values = append(values, value)
+	}
+	value := strings.Join(values, " ")
+
+	if zone == nil {
+		zone = t.location
+	}
+	if zone == nil {
+		zone = time.Local
+	}
+
+	for _, layout := range t.Layouts {
+		switch layout {
+		case "unix", "unixmilli", "unixmicro":
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				continue
+			}
+			switch layout {
+			case "unix":
+				return time.Unix(n, 0).In(zone), nil
+			case "unixmilli":
+				return time.UnixMilli(n).In(zone), nil
+			default:
+				return time.UnixMicro(n).In(zone), nil
+			}
+		default:
+			if ts, err := time.ParseInLocation(layout, value, zone); err == nil {
+				return ts, nil
+			}
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("timestamp '%v' matches none of the layouts", value)
+}
+
+//-----------------------------------------------------------------------------
+// Year inference
+//-----------------------------------------------------------------------------
+
+// rolloverSlack is how far past the reference time a record may be
+// (for clock skew, or a zone other than the file's) before it's taken
+// to be from the year before.
+const rolloverSlack = 24 * time.Hour
+
+// A timeline fills in the year of times from layouts without one, such
+// as syslog's. Given the year of the first record, it follows the log
+// forward, moving on a year when the time jumps back by more than half
+// a year (December to January). Otherwise, each time is taken to be in
+// the year before the reference time (the file's modification time) if
+// it would be later than that in the same year.
+type timeline struct {
+	zone *time.Location // overrides the grammar's, if not nil
+	year int
+	ref  time.Time
+	last time.Time
+}
+
+func (tl *timeline) date(ts time.Time) time.Time {
+	if ts.Year() != 0 {
+		return ts
+	}
+
+	inYear := func(year int) time.Time {
+		return time.Date(year, ts.Month(), ts.Day(), ts.Hour(), ts.Minute(),
+			ts.Second(), ts.Nanosecond(), ts.Location())
+	}
+
+	if tl.year == 0 {
+		year := tl.ref.Year()
+		if t := inYear(year); !t.After(tl.ref.Add(rolloverSlack)) {
+			return t
+		}
+		return inYear(year - 1)
+	}
+
+	t := inYear(tl.year)
+	switch {
+	case tl.last.IsZero():
+	case t.Before(tl.last.AddDate(0, -6, 0)):
+		tl.year++
+		t = inYear(tl.year)
+	case t.After(tl.last.AddDate(0, 6, 0)):
+		// A straggler from before the rollover.
+		return inYear(tl.year - 1)
+	}
+	tl.last = t
+	return t
+}
+
+// timestamp returns the record's time in RFC 3339 format.
+func (tl *timeline) timestamp(spec *timestampSpec, env fieldMap) (string, error) {
+	ts, err := spec.parse(env, tl.zone)
+	if err != nil {
+		return "", err
+	}
+	return tl.date(ts).Format(time.RFC3339Nano), nil
+}
@@ -0,0 +1,121 @@
+//
+// Copyright (C) 2017 Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+// syslogTime is the timestamp spec of the syslog grammar, in zone.
+func syslogTime(t *testing.T, zone string) *timestampSpec {
+	t.Helper()
+	spec := &timestampSpec{Fields: []string{"month", "day", "time"}, Layouts: []string{"Jan 2 15:04:05"}, Zone: zone}
+	if err := spec.load(); err != nil {
+		t.Fatal(err)
+	}
+	return spec
+}
+
+func syslogFields(record string) fieldMap {
+	parts := strings.Fields(record)
+	return fieldMap{"month": parts[0], "day": parts[1], "time": parts[2]}
+}
+
+func TestTimeline(t *testing.T) {
+	ref := func(value string) time.Time {
+		ts, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+
+	tests := []struct {
+		name    string
+		year    int
+		ref     time.Time
+		tz      string // as for -tz
+		zone    string // the grammar's
+		records []string
+		want    []string
+	}{
+		{"-year across new year", 2017, time.Time{}, "UTC", "Local",
+			[]string{"Dec 30 23:00:00", "Dec 31 23:59:59", "Jan  1 00:00:01", "Jan  2 08:00:00"},
+			[]string{"2017-12-30T23:00:00Z", "2017-12-31T23:59:59Z", "2018-01-01T00:00:01Z", "2018-01-02T08:00:00Z"}},
+		{"-year with a straggler", 2017, time.Time{}, "UTC", "Local",
+			[]string{"Dec 31 23:59:59", "Jan  1 00:00:01", "Dec 31 23:59:58", "Jan  1 00:00:02"},
+			[]string{"2017-12-31T23:59:59Z", "2018-01-01T00:00:01Z", "2017-12-31T23:59:58Z", "2018-01-01T00:00:02Z"}},
+		{"-year over two new years", 2017, time.Time{}, "UTC", "Local",
+			[]string{"Dec 31 12:00:00", "Jan  1 12:00:00", "Jun  1 12:00:00", "Nov 30 12:00:00", "Jan  1 12:00:00"},
+			[]string{"2017-12-31T12:00:00Z", "2018-01-01T12:00:00Z", "2018-06-01T12:00:00Z",
+				"2018-11-30T12:00:00Z", "2019-01-01T12:00:00Z"}},
+		{"-year and -tz", 2017, time.Time{}, "America/New_York", "UTC",
+			[]string{"Dec 31 23:59:59", "Jan  1 00:00:01", "Jun 30 12:00:00"},
+			[]string{"2017-12-31T23:59:59-05:00", "2018-01-01T00:00:01-05:00", "2018-06-30T12:00:00-04:00"}},
+		{"-year in the grammar's zone", 2017, time.Time{}, "", "Asia/Tokyo",
+			[]string{"Dec 31 23:59:59", "Jan  1 00:00:01"},
+			[]string{"2017-12-31T23:59:59+09:00", "2018-01-01T00:00:01+09:00"}},
+		{"up to the file's time", 0, ref("2018-01-15T12:00:00Z"), "UTC", "Local",
+			[]string{"Dec 31 23:59:59", "Jan  1 00:00:01", "Jan 16 11:00:00", "Jan 17 00:00:00"},
+			[]string{"2017-12-31T23:59:59Z", "2018-01-01T00:00:01Z", "2018-01-16T11:00:00Z", "2017-01-17T00:00:00Z"}},
+		{"up to the file's time, with -tz", 0, ref("2018-01-01T03:00:00Z"), "America/New_York", "UTC",
+			[]string{"Dec 31 23:00:00", "Jan  1 01:00:00"},
+			[]string{"2017-12-31T23:00:00-05:00", "2018-01-01T01:00:00-05:00"}},
+	}
+
+	for _, test := range tests {
+		tl := &timeline{year: test.year, ref: test.ref}
+		if test.tz != "" {
+			zone, err := loadZone(test.tz)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tl.zone = zone
+		}
+		spec := syslogTime(t, test.zone)
+
+		for i, record := range test.records {
+			got, err := tl.timestamp(spec, syslogFields(record))
+			if err != nil {
+				t.Errorf("%v: %v: %v", test.name, record, err)
+			} else if got != test.want[i] {
+				t.Errorf("%v: %v: got %v, want %v", test.name, record, got, test.want[i])
+			}
+		}
+	}
+}
+
+func TestTimelineWithYear(t *testing.T) {
+	spec := &timestampSpec{Fields: []string{"ts"}, Layouts: []string{time.RFC3339}}
+	if err := spec.load(); err != nil {
+		t.Fatal(err)
+	}
+	tl := &timeline{zone: time.UTC, year: 2017}
+
+	// Times with a year are left alone, and don't move the year on.
+	for _, ts := range []string{"2003-10-11T22:14:15Z", "2019-01-01T00:00:00+01:00"} {
+		if got, err := tl.timestamp(spec, fieldMap{"ts": ts}); err != nil || got != ts {
+			t.Errorf("%v: got %v, %v", ts, got, err)
+		}
+	}
+	if tl.year != 2017 || !tl.last.IsZero() {
+		t.Errorf("timeline moved to %v (last %v)", tl.year, tl.last)
+	}
+}
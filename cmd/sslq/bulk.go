@@ -0,0 +1,180 @@
+//
+// Copyright (C) 2017 Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/zentrope/tools/lib"
+)
+
+type bulkResult struct {
+	Target string             `json:"target"`
+	Chain  []*lib.Certificate `json:"chain"`
+	Error  string             `json:"error,omitempty"`
+}
+
+// retryable reports whether err is a network error, timeout or
+// connection closed mid-handshake which may not happen again, rather
+// than one such as a bad cert, a handshake alert or an unknown host
+// which will.
+func retryable(err error) bool {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return !dnsErr.IsNotFound
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "remote error" {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// scanTarget fetches a target's chain, trying again up to retries
+// times if the host can't be reached.
+func scanTarget(target string, opts sourceOptions, retries int) bulkResult {
+	var certs []*x509.Certificate
+	var err error
+
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * 250 * time.Millisecond)
+		}
+		certs, err = findCerts(target, opts)
+		if err == nil {
+			return bulkResult{Target: target, Chain: lib.NewChain(certs, x509.VerifyOptions{})}
+		}
+		if !retryable(err) {
+			break
+		}
+	}
+
+	return bulkResult{Target: target, Chain: make([]*lib.Certificate, 0), Error: err.Error()}
+}
+
+// scanAll scans targets with a pool of workers, calling emit with each
+// result as it arrives.
+func scanAll(targets []string, opts sourceOptions, workers, retries int, emit func(bulkResult)) {
+	jobs := make(chan string)
+	results := make(chan bulkResult)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for target := range jobs {
+				results <- scanTarget(target, opts, retries)
+			}
+		}()
+	}
+
+	go func() {
+		for _, target := range targets {
+			jobs <- target
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	for result := range results {
+		emit(result)
+	}
+}
+
+func bulkUsage(fs *flag.FlagSet) func() {
+	return func() {
+		fmt.Printf("USAGE: ssql bulk [options] [targets.txt]\n\n")
+		fmt.Println("Scan targets (one per line, from the file or stdin) concurrently,")
+		fmt.Println("writing one JSON object per target per line.")
+		fmt.Println("")
+		fmt.Println("OPTIONS:")
+		fs.PrintDefaults()
+	}
+}
+
+func bulkMain(args []string) int {
+	var workers, retries int
+	var sorted bool
+	var opts sourceOptions
+
+	fs := flag.NewFlagSet("bulk", flag.ExitOnError)
+	fs.IntVar(&workers, "workers", 32, "Number of targets to scan at once.")
+	fs.IntVar(&retries, "retries", 0, "Times to retry a target after a network error or timeout.")
+	fs.BoolVar(&sorted, "sorted", false, "Emit results sorted by target rather than as they arrive.")
+	opts.addFlags(fs)
+	fs.Usage = bulkUsage(fs)
+	fs.Parse(args)
+
+	if err := opts.loadClientCert(); err != nil {
+		fmt.Printf("ERROR: %v\n", err)
+		return 1
+	}
+
+	source := "-"
+	if fs.NArg() > 0 {
+		source = fs.Arg(0)
+	}
+
+	targets, err := readTargetsFile(source)
+	if err != nil {
+		fmt.Printf("ERROR: %v\n", err)
+		return 1
+	}
+
+	if workers < 1 {
+		workers = 1
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+
+	if !sorted {
+		scanAll(targets, opts, workers, retries, func(r bulkResult) {
+			enc.Encode(r)
+		})
+		return 0
+	}
+
+	results := make([]bulkResult, 0, len(targets))
+	scanAll(targets, opts, workers, retries, func(r bulkResult) {
+		results = append(results, r)
+	})
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Target < results[j].Target
+	})
+
+	for _, r := range results {
+		enc.Encode(r)
+	}
+	return 0
+}
@@ -0,0 +1,102 @@
+//
+// Copyright (C) 2017 Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRetryable(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+	timeout := &net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}
+	alert := &net.OpError{Op: "remote error", Err: errors.New("tls: handshake failure")}
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"connection refused", refused, true},
+		{"timeout", timeout, true},
+		{"wrapped by a preamble", fmt.Errorf("smtp: %w", timeout), true},
+		{"wrapped by a proxy", fmt.Errorf("proxy http://proxy:3128: %w", refused), true},
+		{"dns timeout", &net.DNSError{Err: "timeout", Name: "example.com", IsTimeout: true}, true},
+		{"unknown host", &net.DNSError{Err: "no such host", Name: "gone.example.com", IsNotFound: true}, false},
+		{"handshake alert", alert, false},
+		{"closed mid-handshake", io.EOF, true},
+		{"cut off mid-record", io.ErrUnexpectedEOF, true},
+		{"closed after a preamble", fmt.Errorf("smtp: %w", io.EOF), true},
+		{"reset", &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}, true},
+		{"bad cert", x509.UnknownAuthorityError{}, false},
+		{"no certs", errors.New("no certs found"), false},
+	}
+
+	for _, test := range tests {
+		if got := retryable(test.err); got != test.want {
+			t.Errorf("%v: retryable(%v) = %v, want %v", test.name, test.err, got, test.want)
+		}
+	}
+}
+
+func TestScanTargetRetries(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	config := &tls.Config{Certificates: server.TLS.Certificates}
+
+	// A flaky load balancer: every other connection is closed before
+	// the handshake.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for i := 0; ; i++ {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			if i%2 == 1 {
+				tls.Server(conn, config).Handshake()
+			} else {
+				// Reading the hello first makes the close an EOF
+				// rather than a reset.
+				conn.Read(make([]byte, 4096))
+			}
+			conn.Close()
+		}
+	}()
+
+	opts := sourceOptions{proto: "tls", proxy: "direct", timeout: 5 * time.Second}
+	if r := scanTarget(l.Addr().String(), opts, 1); r.Error != "" || len(r.Chain) != 1 {
+		t.Errorf("one retry: got %v certs, error %q, want the chain", len(r.Chain), r.Error)
+	}
+	if r := scanTarget(l.Addr().String(), opts, 0); r.Error != "EOF" {
+		t.Errorf("no retries: got error %q, want EOF", r.Error)
+	}
+}
@@ -0,0 +1,265 @@
+//
+// Copyright (C) 2017 Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"time"
+)
+
+// Check statuses, ordered by severity, with Nagios plugin exit codes.
+// A target that couldn't be read outranks a warning, so that it isn't
+// hidden by a cert merely close to expiry.
+type status int
+
+const (
+	statusOK status = iota
+	statusWarning
+	statusUnknown
+	statusCritical
+)
+
+var statusNames = []string{"OK", "WARNING", "UNKNOWN", "CRITICAL"}
+var statusCodes = []int{0, 1, 3, 2}
+
+func (s status) String() string {
+	return statusNames[s]
+}
+
+func (s status) exitCode() int {
+	return statusCodes[s]
+}
+
+func (s status) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+type certCheck struct {
+	Index    int       `json:"index"`
+	Subject  string    `json:"subject"`
+	NotAfter time.Time `json:"notAfter"`
+	Days     int       `json:"days"`
+	Status   status    `json:"status"`
+}
+
+type targetCheck struct {
+	Target string      `json:"target"`
+	Status status      `json:"status"`
+	Error  string      `json:"error,omitempty"`
+	Certs  []certCheck `json:"certs"`
+}
+
+type checkSummary struct {
+	Status   status        `json:"status"`
+	OK       int           `json:"ok"`
+	Warning  int           `json:"warning"`
+	Critical int           `json:"critical"`
+	Unknown  int           `json:"unknown"`
+	Targets  []targetCheck `json:"targets"`
+}
+
+// findCerts loads certs from the file at source, or, if there's no
+// such file, from the network host it names.
+func findCerts(source string, opts sourceOptions) ([]*x509.Certificate, error) {
+	if _, err := os.Stat(source); err == nil {
+		return getCertsFromFile(source, opts)
+	}
+	return getCertsFromNet(source, opts)
+}
+
+// daysUntil returns whole days remaining until t (negative once t has
+// passed).
+func daysUntil(now, t time.Time) int {
+	return int(math.Floor(t.Sub(now).Hours() / 24))
+}
+
+func checkCerts(target string, certs []*x509.Certificate, now time.Time, warn, critical int) targetCheck {
+	result := targetCheck{Target: target, Status: statusOK, Certs: make([]certCheck, 0)}
+
+	for i, cert := range certs {
+		days := daysUntil(now, cert.NotAfter)
+
+		s := statusOK
+		switch {
+		case days <= critical:
+			s = statusCritical
+		case days <= warn:
+			s = statusWarning
+		}
+
+		result.Certs = append(result.Certs, certCheck{
+			Index:    i,
+			Subject:  cert.Subject.CommonName,
+			NotAfter: cert.NotAfter,
+			Days:     days,
+			Status:   s,
+		})
+
+		if s > result.Status {
+			result.Status = s
+		}
+	}
+
+	return result
+}
+
+func (summary *checkSummary) add(result targetCheck) {
+	summary.Targets = append(summary.Targets, result)
+
+	switch result.Status {
+	case statusOK:
+		summary.OK++
+	case statusWarning:
+		summary.Warning++
+	case statusCritical:
+		summary.Critical++
+	case statusUnknown:
+		summary.Unknown++
+	}
+
+	if result.Status > summary.Status {
+		summary.Status = result.Status
+	}
+}
+
+// readTargets returns the non-blank, non-comment lines of r.
+func readTargets(r io.Reader) ([]string, error) {
+	targets := make([]string, 0)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		targets = append(targets, line)
+	}
+	return targets, scanner.Err()
+}
+
+// readTargetsFile reads targets from the named file, or stdin for "-".
+func readTargetsFile(path string) ([]string, error) {
+	if path == "-" {
+		return readTargets(os.Stdin)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return readTargets(f)
+}
+
+func checkTextDump(summary *checkSummary) {
+	for _, t := range summary.Targets {
+		if t.Error != "" {
+			fmt.Printf("%-8v %v: %v\n", t.Status, t.Target, t.Error)
+			continue
+		}
+		for _, c := range t.Certs {
+			fmt.Printf("%-8v %v [%v] %v expires %v (%v days)\n", c.Status, t.Target,
+				c.Index, c.Subject, c.NotAfter.Format(time.RFC3339), c.Days)
+		}
+	}
+	fmt.Printf("SSLQ %v - %v critical, %v warning, %v unknown, %v ok\n", summary.Status,
+		summary.Critical, summary.Warning, summary.Unknown, summary.OK)
+}
+
+func checkUsage(fs *flag.FlagSet) func() {
+	return func() {
+		fmt.Printf("USAGE: ssql check [options] host[:port]|file...\n\n")
+		fmt.Println("Report days until expiry for every cert in each chain. Exits with")
+		fmt.Println("0 (OK), 1 (WARNING), 2 (CRITICAL) or 3 (UNKNOWN), for the worst")
+		fmt.Println("target, where CRITICAL outranks UNKNOWN, which outranks WARNING.")
+		fmt.Println("")
+		fmt.Println("OPTIONS:")
+		fs.PrintDefaults()
+	}
+}
+
+func checkMain(args []string) int {
+	var warn, critical int
+	var targetsFile, format string
+	var opts sourceOptions
+
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	fs.IntVar(&warn, "warn", 30, "Warn when a cert expires within this many days.")
+	fs.IntVar(&critical, "critical", 7, "Critical when a cert expires within this many days.")
+	fs.StringVar(&targetsFile, "f", "", "File of targets, one per line ('-' for stdin).")
+	opts.addFlags(fs)
+	fs.StringVar(&format, "format", "text", "Output format: text or json.")
+	fs.Usage = checkUsage(fs)
+	fs.Parse(args)
+
+	targets := fs.Args()
+
+	if targetsFile != "" {
+		more, err := readTargetsFile(targetsFile)
+		if err != nil {
+			fmt.Printf("ERROR: %v\n", err)
+			return statusUnknown.exitCode()
+		}
+		targets = append(targets, more...)
+	}
+
+	if len(targets) == 0 {
+		fs.Usage()
+		return statusUnknown.exitCode()
+	}
+
+	if format != "text" && format != "json" {
+		fmt.Printf("ERROR: Unrecognized output format: '%v'.\n", format)
+		return statusUnknown.exitCode()
+	}
+
+	if err := opts.loadClientCert(); err != nil {
+		fmt.Printf("ERROR: %v\n", err)
+		return statusUnknown.exitCode()
+	}
+
+	now := time.Now()
+	summary := &checkSummary{Status: statusOK, Targets: make([]targetCheck, 0)}
+
+	for _, target := range targets {
+		certs, err := findCerts(target, opts)
+		if err != nil {
+			summary.add(targetCheck{Target: target, Status: statusUnknown,
+				Error: err.Error(), Certs: make([]certCheck, 0)})
+			continue
+		}
+		summary.add(checkCerts(target, certs, now, warn, critical))
+	}
+
+	if format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(summary)
+	} else {
+		checkTextDump(summary)
+	}
+
+	return summary.Status.exitCode()
+}
@@ -0,0 +1,143 @@
+//
+// Copyright (C) 2017 Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+	"time"
+)
+
+func TestDaysUntil(t *testing.T) {
+	now := time.Date(2017, time.June, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		t    time.Time
+		want int
+	}{
+		{now, 0},
+		{now.Add(23 * time.Hour), 0},
+		{now.Add(24 * time.Hour), 1},
+		{now.Add(30*24*time.Hour - time.Second), 29},
+		{now.Add(-time.Second), -1},
+		{now.Add(-24 * time.Hour), -1},
+		{now.Add(-24*time.Hour - time.Second), -2},
+	}
+
+	for _, test := range tests {
+		if got := daysUntil(now, test.t); got != test.want {
+			t.Errorf("daysUntil(%v): got %v, want %v", test.t.Sub(now), got, test.want)
+		}
+	}
+}
+
+func expiringIn(now time.Time, days int) *x509.Certificate {
+	return &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "www.example.com"},
+		NotAfter: now.Add(time.Duration(days)*24*time.Hour + time.Hour),
+	}
+}
+
+func TestCheckCerts(t *testing.T) {
+	now := time.Date(2017, time.June, 1, 12, 0, 0, 0, time.UTC)
+
+	// With -warn 30 -critical 7.
+	tests := []struct {
+		name  string
+		days  []int
+		want  []status
+		worst status
+	}{
+		{"fine", []int{90}, []status{statusOK}, statusOK},
+		{"just outside warn", []int{31}, []status{statusOK}, statusOK},
+		{"at warn", []int{30}, []status{statusWarning}, statusWarning},
+		{"just outside critical", []int{8}, []status{statusWarning}, statusWarning},
+		{"at critical", []int{7}, []status{statusCritical}, statusCritical},
+		{"expired", []int{-3}, []status{statusCritical}, statusCritical},
+		{"worst of the chain", []int{90, 20, 400}, []status{statusOK, statusWarning, statusOK}, statusWarning},
+		{"critical intermediate", []int{20, 5}, []status{statusWarning, statusCritical}, statusCritical},
+		{"no certs", []int{}, []status{}, statusOK},
+	}
+
+	for _, test := range tests {
+		certs := make([]*x509.Certificate, 0)
+		for _, days := range test.days {
+			certs = append(certs, expiringIn(now, days))
+		}
+
+		result := checkCerts("example.com", certs, now, 30, 7)
+		if result.Status != test.worst {
+			t.Errorf("%v: got %v, want %v", test.name, result.Status, test.worst)
+		}
+		if len(result.Certs) != len(test.want) {
+			t.Errorf("%v: got %v certs, want %v", test.name, len(result.Certs), len(test.want))
+			continue
+		}
+		for i, c := range result.Certs {
+			if c.Status != test.want[i] || c.Days != test.days[i] || c.Index != i {
+				t.Errorf("%v: cert %v got %v (%v days), want %v (%v days)", test.name, i,
+					c.Status, c.Days, test.want[i], test.days[i])
+			}
+		}
+	}
+}
+
+func TestCheckSummary(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []status
+		want     status
+		exit     int
+	}{
+		{"all ok", []status{statusOK, statusOK}, statusOK, 0},
+		{"warning", []status{statusOK, statusWarning}, statusWarning, 1},
+		{"unknown outranks warning", []status{statusWarning, statusUnknown, statusOK}, statusUnknown, 3},
+		{"unknown first", []status{statusUnknown, statusWarning}, statusUnknown, 3},
+		{"critical outranks unknown", []status{statusUnknown, statusCritical, statusWarning}, statusCritical, 2},
+		{"critical first", []status{statusCritical, statusUnknown}, statusCritical, 2},
+		{"nothing", []status{}, statusOK, 0},
+	}
+
+	for _, test := range tests {
+		summary := &checkSummary{Status: statusOK}
+		counts := make(map[status]int)
+		for _, s := range test.statuses {
+			summary.add(targetCheck{Target: "example.com", Status: s})
+			counts[s]++
+		}
+
+		if summary.Status != test.want || summary.Status.exitCode() != test.exit {
+			t.Errorf("%v: got %v (exit %v), want %v (exit %v)", test.name,
+				summary.Status, summary.Status.exitCode(), test.want, test.exit)
+		}
+		if summary.OK != counts[statusOK] || summary.Warning != counts[statusWarning] ||
+			summary.Unknown != counts[statusUnknown] || summary.Critical != counts[statusCritical] {
+			t.Errorf("%v: counted %v ok, %v warning, %v unknown, %v critical", test.name,
+				summary.OK, summary.Warning, summary.Unknown, summary.Critical)
+		}
+		if len(summary.Targets) != len(test.statuses) {
+			t.Errorf("%v: %v targets, want %v", test.name, len(summary.Targets), len(test.statuses))
+		}
+	}
+
+	codes := map[status]int{statusOK: 0, statusWarning: 1, statusCritical: 2, statusUnknown: 3}
+	for s, code := range codes {
+		if s.exitCode() != code {
+			t.Errorf("%v: exit code %v, want %v", s, s.exitCode(), code)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+//
+// Copyright (C) 2017 Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/zentrope/tools/lib"
+)
+
+// Keys expected to change whenever a cert is re-issued.
+var volatileKeys = []string{
+	"cert.serial.number",
+	"cert.signature",
+	"cert.not.valid.before",
+	"cert.not.valid.after",
+	"cert.subject.key.id",
+	"cert.fingerprint",
+	"cert.extensions.subject.key.identifier",
+	"cert.extensions.sct.list",
+}
+
+// chainKeyRE matches the chain position prefixing keys with -chain.
+var chainKeyRE = regexp.MustCompile(`^chain\.\d+\.`)
+
+// chainProperties returns the leaf's properties or, for the whole
+// chain, each cert's properties under chain.N, N being its position.
+func chainProperties(certs []*x509.Certificate, chain bool) *lib.FIFOMap {
+	if !chain {
+		return lib.CertProperties(certs)
+	}
+
+	properties := lib.NewFIFOMap()
+	for i := range certs {
+		props := lib.CertProperties(certs[i:])
+		for _, k := range props.Keys() {
+			properties.Set(fmt.Sprintf("chain.%v.%v", i, k), props.Get(k))
+		}
+	}
+	return properties
+}
+
+// ignorer matches keys equal to, or nested under, any of the names
+// given ("cert." is optional), at any chain position.
+func ignorer(names []string) func(string) bool {
+	prefixes := make([]string, 0)
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !strings.HasPrefix(name, "cert.") {
+			name = "cert." + name
+		}
+		prefixes = append(prefixes, name)
+	}
+
+	return func(key string) bool {
+		key = chainKeyRE.ReplaceAllString(key, "")
+		for _, p := range prefixes {
+			if key == p || strings.HasPrefix(key, p+".") {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func diffTextDump(changes []lib.PropertyChange) {
+	for _, c := range changes {
+		switch c.Kind {
+		case lib.PropertyRemoved:
+			fmt.Printf("- %-35v = %v\n", c.Key, c.Old)
+		case lib.PropertyAdded:
+			fmt.Printf("+ %-35v = %v\n", c.Key, c.New)
+		case lib.PropertyChanged:
+			fmt.Printf("- %-35v = %v\n", c.Key, c.Old)
+			fmt.Printf("+ %-35v = %v\n", c.Key, c.New)
+		}
+	}
+}
+
+func diffUsage(fs *flag.FlagSet) func() {
+	return func() {
+		fmt.Printf("USAGE: ssql diff [options] host[:port]|file host[:port]|file [text|json]\n\n")
+		fmt.Println("Compare the leaf cert properties (or, with -chain, those of every")
+		fmt.Println("cert in the chain) of two sources. Exits with 0 if they're the same,")
+		fmt.Println("1 if they differ, or 2 if a source can't be read.")
+		fmt.Println("")
+		fmt.Println("OPTIONS:")
+		fs.PrintDefaults()
+	}
+}
+
+func diffMain(args []string) int {
+	var ignore string
+	var volatile, chain bool
+	var opts sourceOptions
+
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	fs.StringVar(&ignore, "ignore", "", "Comma separated keys (and the keys under them) to ignore.")
+	fs.BoolVar(&volatile, "volatile", false, "Ignore keys that change on every re-issue (serial, signature, ...).")
+	fs.BoolVar(&chain, "chain", false, "Compare every cert in the chain, by position, not just the leaf.")
+	opts.addFlags(fs)
+	fs.Usage = diffUsage(fs)
+	fs.Parse(args)
+
+	if fs.NArg() < 2 {
+		fs.Usage()
+		return 2
+	}
+
+	format := "text"
+	if fs.NArg() > 2 {
+		format = fs.Arg(2)
+	}
+
+	if format != "text" && format != "json" {
+		fmt.Printf("ERROR: Unrecognized output format: '%v'.\n", format)
+		return 2
+	}
+
+	if err := opts.loadClientCert(); err != nil {
+		fmt.Printf("ERROR: %v\n", err)
+		return 2
+	}
+
+	names := strings.Split(ignore, ",")
+	if volatile {
+		names = append(names, volatileKeys...)
+	}
+
+	sources := make([][]*x509.Certificate, 2)
+	for i := range sources {
+		certs, err := findCerts(fs.Arg(i), opts)
+		if err != nil {
+			fmt.Printf("ERROR: %v: %v\n", fs.Arg(i), err)
+			return 2
+		}
+		sources[i] = certs
+	}
+
+	changes := lib.DiffProperties(chainProperties(sources[0], chain),
+		chainProperties(sources[1], chain), ignorer(names))
+
+	if format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(changes)
+	} else {
+		diffTextDump(changes)
+	}
+
+	if len(changes) > 0 {
+		return 1
+	}
+	return 0
+}
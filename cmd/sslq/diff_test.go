@@ -0,0 +1,51 @@
+//
+// Copyright (C) 2017 Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"testing"
+)
+
+func TestIgnorer(t *testing.T) {
+	ignore := ignorer([]string{"serial.number", " cert.extensions ", ""})
+
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{"cert.serial.number", true},
+		{"cert.serial.numbers", false},
+		{"cert.extensions", true},
+		{"cert.extensions.sct.list", true},
+		{"cert.subject.common.name", false},
+		{"chain.0.cert.serial.number", true},
+		{"chain.12.cert.extensions.sct.list", true},
+		{"chain.1.cert.subject.common.name", false},
+	}
+
+	for _, test := range tests {
+		if got := ignore(test.key); got != test.want {
+			t.Errorf("ignore(%q) = %v, want %v", test.key, got, test.want)
+		}
+	}
+}
+
+func TestDiffSourceErrors(t *testing.T) {
+	if code := diffMain([]string{"127.0.0.1:1", "127.0.0.1:1"}); code != 2 {
+		t.Errorf("diff of unreadable sources exited %v, want 2", code)
+	}
+}
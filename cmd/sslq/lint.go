@@ -0,0 +1,45 @@
+//
+// Copyright (C) 2017 Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"time"
+
+	"github.com/zentrope/tools/lib"
+)
+
+// lintDump prints the problems found with the chain, one per line,
+// and returns the exit status: 1 if any are errors.
+func lintDump(certs []*x509.Certificate, at time.Time) int {
+	findings := lib.Lint(certs, at)
+
+	if len(findings) == 0 {
+		fmt.Println("No problems found.")
+		return 0
+	}
+
+	status := 0
+	for _, f := range findings {
+		fmt.Printf("%-8v %v %-18v %v: %v\n", f.Severity, f.Index, f.Rule, f.Subject, f.Message)
+		if f.Severity == lib.LintError {
+			status = 1
+		}
+	}
+	return status
+}
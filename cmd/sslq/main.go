@@ -18,88 +18,321 @@ package main
 
 import (
 	"bytes"
+	"crypto"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
 	"errors"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
-	"net"
+	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/zentrope/tools/lib"
+	"golang.org/x/crypto/ssh/terminal"
 )
 
-func getCertsFromFile(path string) ([]*x509.Certificate, error) {
+func getCertsFromFile(path string, opts sourceOptions) ([]*x509.Certificate, error) {
 
 	contents, err := ioutil.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
 
-	block, _ := pem.Decode(contents)
+	certs, err := lib.ParseCertificates(contents, opts.password)
 
-	cert, err := x509.ParseCertificate(block.Bytes)
+	if err == lib.ErrPasswordRequired && opts.password == "" {
+		if password, ok := promptPassword(path); ok {
+			certs, err = lib.ParseCertificates(contents, password)
+		}
+	}
+
+	return certs, err
+}
+
+// promptPassword asks for the password for path, if there's someone
+// to ask.
+func promptPassword(path string) (string, bool) {
+	if !terminal.IsTerminal(int(os.Stdin.Fd())) {
+		return "", false
+	}
+	fmt.Fprintf(os.Stderr, "Password for %v: ", path)
+	password, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
 	if err != nil {
-		return nil, err
+		return "", false
 	}
+	return string(password), true
+}
+
+// sourceOptions control how certs are read from files and hosts.
+type sourceOptions struct {
+	password   string
+	proto      string
+	timeout    time.Duration
+	serverName string
+	alpn       string
+	clientCert string
+	clientKey  string
+	proxy      string
+
+	// certificate is the client cert loaded by loadClientCert.
+	certificate *tls.Certificate
+}
 
-	return []*x509.Certificate{cert}, nil
+func (opts *sourceOptions) addFlags(fs *flag.FlagSet) {
+	fs.StringVar(&opts.password, "password", "", "PKCS#12 keystore or private key password (prompted for if needed).")
+	fs.StringVar(&opts.proto, "proto", "tls", "Protocol to speak before the TLS handshake.")
+	fs.DurationVar(&opts.timeout, "timeout", time.Second*3, "Connect (and each exchange) timeout.")
+	fs.StringVar(&opts.serverName, "servername", "", "SNI name to send (defaults to the host).")
+	fs.StringVar(&opts.alpn, "alpn", "", "Comma separated ALPN protocols to offer, e.g. h2,http/1.1.")
+	fs.StringVar(&opts.clientCert, "client-cert", "", "Client cert (and chain) to present if the server asks for one.")
+	fs.StringVar(&opts.clientKey, "client-key", "", "Private key for the client cert (default the cert file).")
+	fs.StringVar(&opts.proxy, "proxy", "", "Proxy URL, or 'direct' for none (default $HTTPS_PROXY or $ALL_PROXY).")
 }
 
-func getCertsFromNet(host string) ([]*x509.Certificate, error) {
-	hostname := fmt.Sprintf("%v:443", host)
+// loadClientCert reads the client cert and key, if any, prompting for
+// the key's password if needed.
+func (opts *sourceOptions) loadClientCert() error {
+	if opts.clientCert == "" {
+		if opts.clientKey != "" {
+			return errors.New("-client-key given without -client-cert")
+		}
+		return nil
+	}
+
+	certs, err := getCertsFromFile(opts.clientCert, *opts)
+	if err != nil {
+		return fmt.Errorf("%v: %v", opts.clientCert, err)
+	}
+
+	keyFile := opts.clientKey
+	if keyFile == "" {
+		keyFile = opts.clientCert
+	}
+	data, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return err
+	}
+	key, err := lib.ParsePrivateKey(data, opts.password)
+	if err == lib.ErrPasswordRequired && opts.password == "" {
+		if password, ok := promptPassword(keyFile); ok {
+			key, err = lib.ParsePrivateKey(data, password)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%v: %v", keyFile, err)
+	}
+
+	pub, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(certs[0].PublicKey) {
+		return fmt.Errorf("%v: key doesn't match the cert in %v", keyFile, opts.clientCert)
+	}
+
+	opts.certificate = &tls.Certificate{PrivateKey: key, Leaf: certs[0]}
+	for _, cert := range certs {
+		opts.certificate.Certificate = append(opts.certificate.Certificate, cert.Raw)
+	}
+	return nil
+}
 
-	dialer := &net.Dialer{Timeout: time.Second * 3}
-	conn, err := tls.DialWithDialer(dialer, "tcp", hostname, &tls.Config{
+// clientCertificate answers a server's request for a client cert with
+// the one loaded, if any, even if it doesn't look acceptable, so that
+// the server gets to say why.
+func (opts sourceOptions) clientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	if opts.certificate != nil {
+		return opts.certificate, nil
+	}
+	return &tls.Certificate{}, nil
+}
+
+// tlsConfig returns the client config for a handshake with host.
+func (opts sourceOptions) tlsConfig(host string) *tls.Config {
+	config := &tls.Config{
+		ServerName:         host,
 		InsecureSkipVerify: true, // We want to see bad certs, too.
+
+		GetClientCertificate: opts.clientCertificate,
+	}
+
+	if opts.serverName != "" {
+		config.ServerName = opts.serverName
+	}
+
+	if opts.alpn != "" {
+		config.NextProtos = strings.Split(opts.alpn, ",")
+	}
+
+	return config
+}
+
+func getCertsFromNet(target string, opts sourceOptions) ([]*x509.Certificate, error) {
+	state, _, err := getStateFromNet(target, opts)
+	if err != nil {
+		return nil, err
+	}
+	return state.PeerCertificates, nil
+}
+
+// getStateFromNet handshakes with target, returning what the server
+// asked of the client if it asked for a client cert.
+func getStateFromNet(target string, opts sourceOptions) (*tls.ConnectionState, *lib.ClientAuthRequest, error) {
+	var request *lib.ClientAuthRequest
+
+	state, err := handshake(target, opts, func(c *tls.Config) {
+		c.GetClientCertificate = func(info *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			request = lib.NewClientAuthRequest(info, opts.certificate)
+			return opts.clientCertificate(info)
+		}
 	})
 
+	if err != nil && request != nil && opts.certificate == nil {
+		err = fmt.Errorf("%w (the server asked for a client cert, see -client-cert)", err)
+	}
+
+	return state, request, err
+}
+
+// handshake connects to the target and completes a TLS handshake,
+// letting tweak restrict the client config first.
+func handshake(target string, opts sourceOptions, tweak func(*tls.Config)) (*tls.ConnectionState, error) {
+
+	proto, host, address, err := parseTarget(target, opts.proto)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := opts.dial(address)
 	if err != nil {
 		return nil, err
 	}
 
 	defer conn.Close()
 
-	state := conn.ConnectionState()
-	certs := state.PeerCertificates
+	conn.SetDeadline(time.Now().Add(opts.timeout))
+
+	if pre := protocols[proto].preamble; pre != nil {
+		if err := pre(conn, host); err != nil {
+			return nil, fmt.Errorf("%v: %w", proto, err)
+		}
+	}
+
+	config := opts.tlsConfig(host)
+	if tweak != nil {
+		tweak(config)
+	}
+
+	tlsConn := tls.Client(conn, config)
 
-	if len(certs) == 0 {
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, err
+	}
+
+	state := tlsConn.ConnectionState()
+
+	if len(state.PeerCertificates) == 0 {
 		return nil, errors.New("no certs found")
 	}
 
-	return certs, nil
+	return &state, nil
 }
 
-func pemDump(certificate *x509.Certificate) {
+// selectCerts returns the certificates at the comma separated chain
+// indexes (0 is the leaf), or the whole chain if there are none.
+func selectCerts(certs []*x509.Certificate, indexes string) ([]*x509.Certificate, error) {
+	if indexes == "" {
+		return certs, nil
+	}
 
-	buf := pem.EncodeToMemory(&pem.Block{
-		Type:  "CERTIFICATE",
-		Bytes: certificate.Raw,
-	})
-	fmt.Print(string(buf))
+	selected := make([]*x509.Certificate, 0)
+	for _, index := range strings.Split(indexes, ",") {
+		i, err := strconv.Atoi(strings.TrimSpace(index))
+		if err != nil {
+			return nil, fmt.Errorf("bad chain index '%v'", index)
+		}
+		if i < 0 || i >= len(certs) {
+			return nil, fmt.Errorf("chain index %v out of range (chain has %v certs)", i, len(certs))
+		}
+		selected = append(selected, certs[i])
+	}
+
+	return selected, nil
+}
+
+func pemDump(certs []*x509.Certificate) {
+	for _, cert := range certs {
+		buf := pem.EncodeToMemory(&pem.Block{
+			Type:  "CERTIFICATE",
+			Bytes: cert.Raw,
+		})
+		fmt.Print(string(buf))
+	}
+}
+
+// describeCerts returns the model of each of the selected certs,
+// verifying the leaf of the chain (if selected) with verify.
+func describeCerts(chain, selected []*x509.Certificate, verify x509.VerifyOptions) []*lib.Certificate {
+	described := make([]*lib.Certificate, 0, len(selected))
+	for _, cert := range selected {
+		c := lib.NewCertificate(cert)
+		if cert == chain[0] {
+			c.Verify(chain[1:], verify)
+		}
+		described = append(described, c)
+	}
+	return described
 }
 
-func jsonDump(certificate *x509.Certificate) {
+func jsonDump(certs []*lib.Certificate, session *lib.TLSSession, revocations []chainRevocation) {
+
+	doc := struct {
+		TLS          *lib.TLSSession    `json:"tls,omitempty"`
+		Certificates []*lib.Certificate `json:"certificates"`
+		Revocation   []chainRevocation  `json:"revocation,omitempty"`
+	}{session, certs, revocations}
 
 	buf := new(bytes.Buffer)
 	enc := json.NewEncoder(buf)
 	enc.SetIndent("", "  ")
 
-	if err := enc.Encode(certificate); err != nil {
+	if err := enc.Encode(doc); err != nil {
 		log.Fatal(err)
 	}
 
 	fmt.Printf("%v", buf.String())
 }
 
-func textDump(certs []*x509.Certificate) {
-	props := lib.CertProperties(certs)
-	for _, k := range props.Keys() {
-		fmt.Printf("%-35v = %v\n", k, props.Get(k))
+// textDump prints the properties of each cert, a blank line apart,
+// then those of the session and revocation checks.
+func textDump(certs []*lib.Certificate, session *lib.TLSSession, revocations []chainRevocation) {
+
+	for _, cert := range certs {
+		props := cert.Properties()
+		for _, k := range props.Keys() {
+			fmt.Printf("%-35v = %v\n", k, props.Get(k))
+		}
+		if len(certs) > 1 {
+			fmt.Println()
+		}
+	}
+
+	all := make([]*lib.FIFOMap, 0)
+	if session != nil {
+		all = append(all, session.Properties())
+	}
+	all = append(all, revocationProperties(revocations))
+
+	for _, props := range all {
+		for _, k := range props.Keys() {
+			fmt.Printf("%-35v = %v\n", k, props.Get(k))
+		}
 	}
 }
 
@@ -108,60 +341,174 @@ func usage(errorMsg string, params ...interface{}) {
 		msg := fmt.Sprintf(errorMsg, params...)
 		fmt.Printf("ERROR: %v\n\n", msg)
 	}
-	fmt.Printf("USAGE: ssql hostname|file.pem [text|cert|pem|json]\n\n")
+	fmt.Printf("USAGE: ssql [options] host[:port]|file [text|cert|pem|json|lint]\n")
+	fmt.Printf("       ssql check [options] host[:port]|file...\n")
+	fmt.Printf("       ssql bulk [options] [targets.txt]\n")
+	fmt.Printf("       ssql scan [options] host[:port] [text|json]\n")
+	fmt.Printf("       ssql diff [options] source1 source2 [text|json]\n")
+	fmt.Printf("       ssql match [options] file|dir...\n\n")
 	fmt.Println("FORMATS:")
-	fmt.Println("  cert | pem     - PEM base64-encoded format")
-	fmt.Println("  json           - JSON format")
+	fmt.Println("  cert | pem     - PEM base64-encoded format (whole chain)")
+	fmt.Println("  json           - JSON format (TLS session and one object per cert)")
 	fmt.Println("  text (default) - key/value text (like Java properties)")
+	fmt.Println("  lint           - problems with the chain, with severities")
+	fmt.Println("")
+	fmt.Println("OPTIONS:")
+	fmt.Println("  -certs 0,1     - only output these chain indexes (0 is the leaf)")
+	fmt.Println("  -proto tls     - STARTTLS protocol: " + strings.Join(protocolNames(), ", "))
+	fmt.Println("  -timeout 3s    - connect (and each exchange) timeout")
+	fmt.Println("  -servername x  - SNI name to send (defaults to the host)")
+	fmt.Println("  -alpn h2,...   - comma separated ALPN protocols to offer")
+	fmt.Println("  -password x    - PKCS#12 keystore or private key password (prompted for if needed)")
+	fmt.Println("  -client-cert x - client cert (and chain) to present if asked for one")
+	fmt.Println("  -client-key x  - private key for the client cert (default the cert file)")
+	fmt.Println("  -proxy url     - HTTP or SOCKS5 proxy, or direct (default $HTTPS_PROXY, $ALL_PROXY)")
+	fmt.Println("  -cafile x      - verify against the roots in this file")
+	fmt.Println("  -hostname x    - verify the cert is valid for this host name")
+	fmt.Println("  -at 2018-06-01 - verify as of this time (RFC 3339 or YYYY-MM-DD)")
+	fmt.Println("  -eku a,b       - extended key usages required (default serverAuth)")
+	fmt.Println("  -revocation    - check OCSP (stapled or live) and CRLs for each cert")
+	fmt.Println("  -complete      - reorder the chain, fetching missing intermediates (AIA)")
+	fmt.Println("  -aia-cache x   - cache fetched intermediates here (default user cache)")
+	fmt.Println("")
+	fmt.Println("Files may be PEM (any number of certs), DER, PKCS#7 or PKCS#12.")
+	fmt.Println("")
+	fmt.Println("The protocol may also be given as a prefix, e.g. smtp://mail.example.com.")
 }
 
 func mustFindParams(args []string) (string, string) {
 	format := "text"
 
-	if len(args) < 2 || args[1] == "help" {
+	if len(args) < 1 || args[0] == "help" {
 		usage("")
 		os.Exit(0)
 	}
 
-	if len(args) >= 3 {
-		format = args[2]
+	if len(args) >= 2 {
+		format = args[1]
 	}
 
-	host := args[1]
+	host := args[0]
 	return host, format
 }
 
-func mustFindCerts(host string) []*x509.Certificate {
-	var certs []*x509.Certificate
-	var err, err2 error
+// mustFindCerts returns the certs in the host file or presented by
+// the host, with the negotiated session in the latter case.
+func mustFindCerts(host string, opts sourceOptions) ([]*x509.Certificate, *lib.TLSSession) {
+	certs, err := getCertsFromFile(host, opts)
+	if err == nil {
+		return certs, nil
+	}
 
-	certs, err = getCertsFromFile(host)
-	if err != nil {
-		certs, err2 = getCertsFromNet(host)
-		if err2 != nil {
-			fmt.Printf("ERROR: %v\n", err)
-			fmt.Printf("ERROR: %v\n", err2)
-			os.Exit(1)
+	state, request, err2 := getStateFromNet(host, opts)
+	if err2 != nil {
+		fmt.Printf("ERROR: %v\n", err)
+		fmt.Printf("ERROR: %v\n", err2)
+		os.Exit(1)
+	}
+
+	session := lib.NewTLSSession(*state)
+	session.ClientAuth = request
+	return state.PeerCertificates, session
+}
+
+// completeChain reorders certs and fetches any missing intermediates
+// up to one of the roots (the system's if nil), warning (on stderr, so
+// a pem bundle stays clean) about those that couldn't be found.
+func completeChain(certs []*x509.Certificate, cacheDir string, client *http.Client, roots *x509.CertPool) []*x509.Certificate {
+	if cacheDir == "" {
+		if dir, err := os.UserCacheDir(); err == nil {
+			cacheDir = filepath.Join(dir, "sslq", "aia")
 		}
 	}
 
-	return certs
+	fetcher := &lib.AIAFetcher{Client: client, CacheDir: cacheDir, Roots: roots}
+	chain, errs := fetcher.CompleteChain(certs)
+	for _, err := range errs {
+		fmt.Fprintf(os.Stderr, "WARNING: %v\n", err)
+	}
+	return chain
+}
+
+func mustSelectCerts(certs []*x509.Certificate, indexes string) []*x509.Certificate {
+	selected, err := selectCerts(certs, indexes)
+	if err != nil {
+		fmt.Printf("ERROR: %v\n", err)
+		os.Exit(1)
+	}
+	return selected
 }
 
 func main() {
-	host, format := mustFindParams(os.Args)
-	certs := mustFindCerts(host)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "check":
+			os.Exit(checkMain(os.Args[2:]))
+		case "bulk":
+			os.Exit(bulkMain(os.Args[2:]))
+		case "scan":
+			os.Exit(scanMain(os.Args[2:]))
+		case "diff":
+			os.Exit(diffMain(os.Args[2:]))
+		case "match":
+			os.Exit(matchMain(os.Args[2:]))
+		}
+	}
+
+	var indexes string
+	var revocation, complete bool
+	var cacheDir string
+	var opts sourceOptions
+	var verify verifyFlags
+
+	flag.StringVar(&indexes, "certs", "", "Comma separated chain indexes to output (0 is the leaf).")
+	flag.BoolVar(&revocation, "revocation", false, "Check OCSP (stapled or live) and CRLs for each cert.")
+	flag.BoolVar(&complete, "complete", false, "Reorder the chain, fetching missing intermediates via AIA.")
+	flag.StringVar(&cacheDir, "aia-cache", "", "Directory to cache fetched intermediates in (default user cache dir).")
+	opts.addFlags(flag.CommandLine)
+	verify.addFlags(flag.CommandLine)
+	flag.Usage = func() { usage("") }
+	flag.Parse()
+
+	host, format := mustFindParams(flag.Args())
+
+	verifyOpts, err := verify.options()
+	if err == nil {
+		err = opts.loadClientCert()
+	}
+	if err != nil {
+		fmt.Printf("ERROR: %v\n", err)
+		os.Exit(1)
+	}
+	certs, session := mustFindCerts(host, opts)
+	if complete {
+		certs = completeChain(certs, cacheDir, opts.httpClient(), verifyOpts.Roots)
+	}
+
+	var revocations []chainRevocation
+	if revocation {
+		revocations = checkChainRevocation(certs, session, opts.httpClient())
+	}
 
 	switch format {
 
 	case "cert", "pem":
-		pemDump(certs[0])
+		pemDump(mustSelectCerts(certs, indexes))
 
 	case "json":
-		jsonDump(certs[0])
+		selected := mustSelectCerts(certs, indexes)
+		jsonDump(describeCerts(certs, selected, verifyOpts), session, revocations)
 
 	case "text":
-		textDump(certs)
+		// Just the leaf, unless asked for others.
+		selected := certs[:1]
+		if indexes != "" {
+			selected = mustSelectCerts(certs, indexes)
+		}
+		textDump(describeCerts(certs, selected, verifyOpts), session, revocations)
+
+	case "lint":
+		os.Exit(lintDump(certs, verifyOpts.CurrentTime))
 
 	default:
 		usage("Unrecognized output format: '%v'.", format)
@@ -0,0 +1,319 @@
+//
+// Copyright (C) 2017 Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/zentrope/tools/lib"
+)
+
+// certRef is a cert found in one of the files matched.
+type certRef struct {
+	File    string `json:"file"`
+	Index   int    `json:"index"`
+	Subject string `json:"subject"`
+	HasKey  bool   `json:"hasKey"`
+
+	cert *x509.Certificate
+}
+
+// keyMatch is a private key and the certs for it.
+type keyMatch struct {
+	File      string         `json:"file"`
+	PublicKey *lib.PublicKey `json:"publicKey,omitempty"`
+	Certs     []*certRef     `json:"certs"`
+	Error     string         `json:"error,omitempty"`
+
+	key crypto.Signer
+}
+
+// orderProblem is a bundle whose certs aren't leaf first, each issued
+// by the next.
+type orderProblem struct {
+	File string `json:"file"`
+	lib.LintFinding
+}
+
+type matchReport struct {
+	Keys   []*keyMatch    `json:"keys"`
+	Certs  []*certRef     `json:"certs"`
+	Order  []orderProblem `json:"order"`
+	Errors []string       `json:"errors"`
+}
+
+// problems counts what's wrong: orphaned or unreadable keys, bundles
+// out of order and files that couldn't be read.
+func (r *matchReport) problems() int {
+	n := len(r.Order) + len(r.Errors)
+	for _, k := range r.Keys {
+		if k.Error != "" || len(k.Certs) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// matchFiles expands directories in paths into the files under them.
+func matchFiles(paths []string) ([]string, error) {
+	files := make([]string, 0)
+	for _, path := range paths {
+		err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.Mode().IsRegular() {
+				files = append(files, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// newKeyMatch describes key, read from file, or the error reading it.
+func newKeyMatch(file string, key crypto.Signer, err error) *keyMatch {
+	k := &keyMatch{File: file, Certs: make([]*certRef, 0), key: key}
+	if err == nil {
+		var pk lib.PublicKey
+		if pk, err = lib.DescribePublicKey(key.Public()); err == nil {
+			k.PublicKey = &pk
+		}
+	}
+	if err != nil {
+		k.Error = err.Error()
+	}
+	return k
+}
+
+// hasLeaf is whether certs include a leaf, so are a chain with an
+// order to check rather than a bundle of CAs, such as /etc/ssl/certs.
+func hasLeaf(certs []*x509.Certificate) bool {
+	for _, cert := range certs {
+		if !cert.IsCA && !bytes.Equal(cert.RawIssuer, cert.RawSubject) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchKeys(files []string, password string) *matchReport {
+	report := &matchReport{
+		Keys:   make([]*keyMatch, 0),
+		Certs:  make([]*certRef, 0),
+		Order:  make([]orderProblem, 0),
+		Errors: make([]string, 0),
+	}
+
+	var orderRule []lib.LintRule
+	for _, rule := range lib.LintRules {
+		if rule.Name == "chain.order" {
+			orderRule = append(orderRule, rule)
+		}
+	}
+
+	// A password typed in for one file is tried on the rest.
+	ask := func(file string, parse func(string) error) error {
+		err := parse(password)
+		if err == lib.ErrPasswordRequired && password == "" {
+			if typed, ok := promptPassword(file); ok {
+				password = typed
+				err = parse(password)
+			}
+		}
+		return err
+	}
+
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			report.Errors = append(report.Errors, err.Error())
+			continue
+		}
+
+		addKey := func(key crypto.Signer, err error) {
+			report.Keys = append(report.Keys, newKeyMatch(file, key, err))
+		}
+
+		switch {
+		case bytes.Contains(data, []byte("PRIVATE KEY-----")):
+			var key crypto.Signer
+			err := ask(file, func(password string) (err error) {
+				key, err = lib.ParsePrivateKey(data, password)
+				return err
+			})
+			addKey(key, err)
+
+		case !bytes.Contains(data, []byte("-----BEGIN")):
+			// Keys in a PKCS#12 keystore. If it can't be opened, that's
+			// reported when reading its certs.
+			var keys []crypto.Signer
+			err := ask(file, func(password string) (err error) {
+				_, keys, err = lib.ParsePKCS12(data, password)
+				return err
+			})
+			if err == nil {
+				for _, key := range keys {
+					addKey(key, nil)
+				}
+			}
+		}
+
+		var certs []*x509.Certificate
+		err = ask(file, func(password string) (err error) {
+			certs, err = lib.ParseCertificates(data, password)
+			return err
+		})
+		if err != nil {
+			// Keys, CSRs and anything else without certs is fine, but
+			// not a keystore we can't open.
+			if err == lib.ErrPasswordRequired {
+				report.Errors = append(report.Errors, fmt.Sprintf("%v: %v", file, err))
+			}
+			continue
+		}
+
+		for i, cert := range certs {
+			report.Certs = append(report.Certs, &certRef{File: file, Index: i,
+				Subject: lib.CertName(cert), cert: cert})
+		}
+
+		if hasLeaf(certs) {
+			for _, f := range lib.LintWith(orderRule, certs, time.Now()) {
+				report.Order = append(report.Order, orderProblem{file, f})
+			}
+		}
+	}
+
+	type publicKey interface {
+		Equal(crypto.PublicKey) bool
+	}
+
+	for _, k := range report.Keys {
+		if k.key == nil {
+			continue
+		}
+		pub, ok := k.key.Public().(publicKey)
+		if !ok {
+			continue
+		}
+		for _, c := range report.Certs {
+			if pub.Equal(c.cert.PublicKey) {
+				c.HasKey = true
+				k.Certs = append(k.Certs, c)
+			}
+		}
+	}
+
+	return report
+}
+
+func matchTextDump(report *matchReport) {
+	for _, k := range report.Keys {
+		switch {
+		case k.Error != "":
+			fmt.Printf("%-7v %v: %v\n", "error", k.File, k.Error)
+		case len(k.Certs) == 0:
+			fmt.Printf("%-7v %v (%v %v, pin %v)\n", "orphan", k.File,
+				k.PublicKey.Algorithm, k.PublicKey.Size, k.PublicKey.PinSHA256)
+		default:
+			for _, c := range k.Certs {
+				fmt.Printf("%-7v %v -> %v [%v] %v\n", "match", k.File, c.File, c.Index, c.Subject)
+			}
+		}
+	}
+
+	// Only leaf certs are expected to have keys at hand.
+	for _, c := range report.Certs {
+		if !c.HasKey && !c.cert.IsCA {
+			fmt.Printf("%-7v %v [%v] %v\n", "no key", c.File, c.Index, c.Subject)
+		}
+	}
+
+	for _, o := range report.Order {
+		fmt.Printf("%-7v %v [%v] %v: %v\n", "order", o.File, o.Index, o.Subject, o.Message)
+	}
+
+	for _, e := range report.Errors {
+		fmt.Printf("%-7v %v\n", "error", e)
+	}
+}
+
+func matchUsage(fs *flag.FlagSet) func() {
+	return func() {
+		fmt.Printf("USAGE: ssql match [options] file|dir...\n\n")
+		fmt.Println("Match private keys (PKCS#1, PKCS#8 or EC, encrypted or not) with")
+		fmt.Println("the certs for them, and check bundles are in order. Exits with 1")
+		fmt.Println("if there are orphaned keys or bundles out of order.")
+		fmt.Println("")
+		fmt.Println("OPTIONS:")
+		fs.PrintDefaults()
+	}
+}
+
+func matchMain(args []string) int {
+	var password, format string
+
+	fs := flag.NewFlagSet("match", flag.ExitOnError)
+	fs.StringVar(&password, "password", "", "Password for encrypted keys and keystores (prompted for if needed).")
+	fs.StringVar(&format, "format", "text", "Output format: text or json.")
+	fs.Usage = matchUsage(fs)
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	if format != "text" && format != "json" {
+		fmt.Printf("ERROR: Unrecognized output format: '%v'.\n", format)
+		return 2
+	}
+
+	files, err := matchFiles(fs.Args())
+	if err != nil {
+		fmt.Printf("ERROR: %v\n", err)
+		return 2
+	}
+
+	report := matchKeys(files, password)
+
+	if format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		matchTextDump(report)
+	}
+
+	if report.problems() > 0 {
+		return 1
+	}
+	return 0
+}
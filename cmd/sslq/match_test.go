@@ -0,0 +1,163 @@
+//
+// Copyright (C) 2017 Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/zentrope/tools/lib"
+)
+
+// signedCert makes a cert for name signed by parent, or self-signed if
+// parent is nil, returning it with its key.
+func signedCert(t *testing.T, name string, isCA bool, parent *x509.Certificate, parentKey crypto.Signer) (*x509.Certificate, crypto.Signer) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+	}
+	if isCA {
+		template.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		template.DNSNames = []string{name}
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func writeCerts(t *testing.T, path string, certs ...*x509.Certificate) {
+	t.Helper()
+	data := make([]byte, 0)
+	for _, cert := range certs {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMatchOrder(t *testing.T) {
+	root, rootKey := signedCert(t, "Test Root", true, nil, nil)
+	other, _ := signedCert(t, "Other Root", true, nil, nil)
+	inter, interKey := signedCert(t, "Test Intermediate", true, root, rootKey)
+	leaf, leafKey := signedCert(t, "www.example.com", false, inter, interKey)
+
+	dir := t.TempDir()
+	writeCerts(t, filepath.Join(dir, "chain.pem"), leaf, inter)
+	writeCerts(t, filepath.Join(dir, "reversed.pem"), inter, leaf)
+	writeCerts(t, filepath.Join(dir, "roots.pem"), root, other, inter)
+	data, err := lib.EncodePrivateKey(leafKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "key.pem"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := matchFiles([]string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := matchKeys(files, "")
+
+	// Only the reversed chain; the CA bundle has no order.
+	got := make([]string, 0)
+	for _, o := range report.Order {
+		got = append(got, filepath.Base(o.File))
+	}
+	sort.Strings(got)
+	if want := []string{"reversed.pem"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("order problems in %v, want %v", got, want)
+	}
+
+	if len(report.Keys) != 1 || len(report.Keys[0].Certs) != 2 || report.problems() != 1 {
+		t.Errorf("%v keys, matching %v certs, %v problems", len(report.Keys), len(report.Keys[0].Certs),
+			report.problems())
+	}
+}
+
+// oddSigner is a key with a public key that can't be described.
+type oddSigner struct{}
+
+func (oddSigner) Public() crypto.PublicKey { return struct{}{} }
+
+func (oddSigner) Sign(io.Reader, []byte, crypto.SignerOpts) ([]byte, error) {
+	return nil, errors.New("can't sign")
+}
+
+func TestNewKeyMatch(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		key     crypto.Signer
+		err     error
+		wantErr bool
+	}{
+		{"ecdsa", key, nil, false},
+		{"unreadable", nil, lib.ErrPasswordRequired, true},
+		{"undescribable", oddSigner{}, nil, true},
+	}
+
+	report := &matchReport{}
+	for _, test := range tests {
+		k := newKeyMatch(test.name, test.key, test.err)
+		if (k.Error != "") != test.wantErr || (k.PublicKey == nil) != test.wantErr {
+			t.Errorf("%v: error %q, public key %v", test.name, k.Error, k.PublicKey)
+		}
+		report.Keys = append(report.Keys, k)
+	}
+
+	// None are orphans with no public key to show.
+	matchTextDump(report)
+}
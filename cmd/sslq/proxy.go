@@ -0,0 +1,363 @@
+//
+// Copyright (C) 2017 Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// proxyPorts are the ports assumed for proxies given without one.
+var proxyPorts = map[string]string{
+	"http":    "80",
+	"https":   "443",
+	"socks5":  "1080",
+	"socks5h": "1080",
+}
+
+func getenv(name string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return os.Getenv(strings.ToLower(name))
+}
+
+// noProxy reports whether NO_PROXY says to go direct to host. Entries
+// are host names (matching subdomains, too), IPs, CIDR blocks or "*".
+func noProxy(host string) bool {
+	ip := net.ParseIP(host)
+	for _, entry := range strings.Split(getenv("NO_PROXY"), ",") {
+		entry = strings.TrimSpace(entry)
+		if h, _, err := net.SplitHostPort(entry); err == nil {
+			entry = h
+		}
+		switch {
+		case entry == "":
+			continue
+		case entry == "*":
+			return true
+		case ip != nil:
+			if _, block, err := net.ParseCIDR(entry); err == nil && block.Contains(ip) {
+				return true
+			}
+			if ip.Equal(net.ParseIP(entry)) {
+				return true
+			}
+		default:
+			entry = strings.TrimPrefix(strings.ToLower(entry), ".")
+			host = strings.ToLower(host)
+			if host == entry || strings.HasSuffix(host, "."+entry) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseProxy parses a proxy URL, taking one without a scheme to be an
+// HTTP proxy.
+func parseProxy(raw string) (*url.URL, error) {
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	port, ok := proxyPorts[u.Scheme]
+	if !ok {
+		return nil, fmt.Errorf("unsupported proxy scheme '%v' (use http, https or socks5)", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("no host in proxy '%v'", u.Redacted())
+	}
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), port)
+	}
+	return u, nil
+}
+
+// proxyFor returns the proxy to reach host through, if any: the one
+// given with -proxy ("direct" for none), or else the first of the
+// environment variables set, unless NO_PROXY excludes the host.
+func (opts sourceOptions) proxyFor(host string, vars ...string) (*url.URL, error) {
+	raw := opts.proxy
+	if raw == "" && !noProxy(host) {
+		for _, name := range vars {
+			if raw = getenv(name); raw != "" {
+				break
+			}
+		}
+	}
+	if raw == "" || raw == "direct" {
+		return nil, nil
+	}
+	return parseProxy(raw)
+}
+
+// dial connects to address, tunnelling through a proxy if there is
+// one for it.
+func (opts sourceOptions) dial(address string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	proxy, err := opts.proxyFor(host, "HTTPS_PROXY", "ALL_PROXY")
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{Timeout: opts.timeout}
+	if proxy == nil {
+		return dialer.Dial("tcp", address)
+	}
+
+	conn, err := dialer.Dial("tcp", proxy.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Now().Add(opts.timeout))
+
+	if proxy.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: proxy.Hostname()})
+		if err = tlsConn.Handshake(); err == nil {
+			conn = tlsConn
+		}
+	}
+
+	tunnel := conn
+	if err == nil {
+		if strings.HasPrefix(proxy.Scheme, "socks5") {
+			err = socks5Connect(conn, address, proxy.User)
+		} else {
+			tunnel, err = httpConnect(conn, address, proxy.User)
+		}
+	}
+
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %v: %w", proxy.Redacted(), err)
+	}
+
+	tunnel.SetDeadline(time.Time{})
+	return tunnel, nil
+}
+
+// httpClient returns a client for OCSP, CRL and AIA fetches which uses
+// the same proxy as the handshake, or HTTP_PROXY and friends.
+func (opts sourceOptions) httpClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = func(req *http.Request) (*url.URL, error) {
+		return opts.proxyFor(req.URL.Hostname(), strings.ToUpper(req.URL.Scheme)+"_PROXY", "ALL_PROXY")
+	}
+	return &http.Client{Timeout: opts.timeout, Transport: transport}
+}
+
+//-----------------------------------------------------------------------------
+// HTTP CONNECT
+//-----------------------------------------------------------------------------
+
+// bufferedConn reads what the proxy sent after its response (such as
+// an SMTP greeting) before reading from the connection.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func httpConnect(conn net.Conn, address string, user *url.Userinfo) (net.Conn, error) {
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+	if user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("CONNECT %v: %v", address, resp.Status)
+	}
+
+	return &bufferedConn{conn, r}, nil
+}
+
+//-----------------------------------------------------------------------------
+// SOCKS5 (RFC 1928, with RFC 1929 username/password auth)
+//-----------------------------------------------------------------------------
+
+const (
+	socksVersion      = 5
+	socksNoAuth       = 0
+	socksPasswordAuth = 2
+	socksNoAcceptable = 0xff
+	socksConnect      = 1
+	socksIPv4         = 1
+	socksDomain       = 3
+	socksIPv6         = 4
+)
+
+var socksReplies = map[byte]string{
+	1: "general failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+// socks5Connect asks the proxy to connect to address. Host names are
+// passed to the proxy to resolve.
+func socks5Connect(conn net.Conn, address string, user *url.Userinfo) error {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return fmt.Errorf("bad port '%v'", portStr)
+	}
+
+	methods := []byte{socksNoAuth}
+	if user != nil {
+		methods = []byte{socksPasswordAuth}
+	}
+	greeting := append([]byte{socksVersion, byte(len(methods))}, methods...)
+	if _, err := conn.Write(greeting); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != socksVersion {
+		return errors.New("not a SOCKS5 proxy")
+	}
+
+	switch reply[1] {
+	case socksNoAuth:
+	case socksPasswordAuth:
+		if user == nil {
+			return errors.New("SOCKS5 proxy wants a username and password")
+		}
+		password, _ := user.Password()
+		name := user.Username()
+		if len(name) > 255 || len(password) > 255 {
+			return errors.New("SOCKS5 username or password too long")
+		}
+		auth := []byte{1, byte(len(name))}
+		auth = append(auth, name...)
+		auth = append(auth, byte(len(password)))
+		auth = append(auth, password...)
+		if _, err := conn.Write(auth); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[1] != 0 {
+			return errors.New("SOCKS5 authentication failed")
+		}
+	case socksNoAcceptable:
+		return errors.New("SOCKS5 proxy accepts none of our authentication methods")
+	default:
+		return fmt.Errorf("SOCKS5 proxy chose unknown authentication method %v", reply[1])
+	}
+
+	req := []byte{socksVersion, socksConnect, 0}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return errors.New("host name too long for SOCKS5")
+		}
+		req = append(req, socksDomain, byte(len(host)))
+		req = append(req, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(append(req, socksIPv4), ip4...)
+	} else {
+		req = append(append(req, socksIPv6), ip.To16()...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	// VER REP RSV ATYP, then the bound address and port, which we
+	// don't need.
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return err
+	}
+	if head[1] != 0 {
+		reason, ok := socksReplies[head[1]]
+		if !ok {
+			reason = fmt.Sprintf("error %v", head[1])
+		}
+		return fmt.Errorf("SOCKS5 connect to %v: %v", address, reason)
+	}
+
+	var skip int
+	switch head[3] {
+	case socksIPv4:
+		skip = net.IPv4len
+	case socksIPv6:
+		skip = net.IPv6len
+	case socksDomain:
+		n := make([]byte, 1)
+		if _, err := io.ReadFull(conn, n); err != nil {
+			return err
+		}
+		skip = int(n[0])
+	default:
+		return fmt.Errorf("SOCKS5 reply with unknown address type %v", head[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip+2))
+	return err
+}
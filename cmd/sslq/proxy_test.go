@@ -0,0 +1,294 @@
+//
+// Copyright (C) 2017 Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	proxyTarget   = "www.example.com:443"
+	proxyGreeting = "hello from www.example.com\r\n"
+)
+
+// proxyServer runs a stand-in proxy for one connection, returning its
+// address. Once the tunnel is up, the stand-in sends proxyGreeting as
+// the target would.
+func proxyServer(t *testing.T, serve func(t *testing.T, conn net.Conn)) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		serve(t, conn)
+	}()
+	t.Cleanup(func() {
+		l.Close()
+		<-done
+	})
+	return l.Addr().String()
+}
+
+// dialThrough dials proxyTarget through proxy, returning what the
+// target said first.
+func dialThrough(proxy string) (string, error) {
+	opts := sourceOptions{proxy: proxy, timeout: 5 * time.Second}
+	conn, err := opts.dial(proxyTarget)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	return bufio.NewReader(conn).ReadString('\n')
+}
+
+// connectServer answers a CONNECT with status if it carries the
+// Proxy-Authorization wanted (if any), or 407 if not.
+func connectServer(auth string, status int) func(t *testing.T, conn net.Conn) {
+	return func(t *testing.T, conn net.Conn) {
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			t.Errorf("proxy: %v", err)
+			return
+		}
+		if req.Method != "CONNECT" || req.Host != proxyTarget {
+			t.Errorf("proxy got %v %v, want CONNECT %v", req.Method, req.Host, proxyTarget)
+		}
+		if auth != "" && req.Header.Get("Proxy-Authorization") != auth {
+			status = http.StatusProxyAuthRequired
+		}
+
+		// The greeting goes in the same write, so it has to be read
+		// from what was buffered with the response.
+		response := fmt.Sprintf("HTTP/1.1 %v %v\r\n\r\n", status, http.StatusText(status))
+		if status == http.StatusOK {
+			response += proxyGreeting
+		}
+		io.WriteString(conn, response)
+	}
+}
+
+func TestHTTPConnect(t *testing.T) {
+	tests := []struct {
+		name    string
+		user    string
+		auth    string
+		status  int
+		wantErr string
+	}{
+		{"no auth", "", "", http.StatusOK, ""},
+		{"auth", "alice:s3cret@", "Basic YWxpY2U6czNjcmV0", http.StatusOK, ""},
+		{"auth missing", "", "Basic YWxpY2U6czNjcmV0", http.StatusOK, "407 Proxy Authentication Required"},
+		{"wrong auth", "alice:guess@", "Basic YWxpY2U6czNjcmV0", http.StatusOK, "407"},
+		{"refused", "", "", http.StatusForbidden, "CONNECT www.example.com:443: 403 Forbidden"},
+	}
+
+	for _, test := range tests {
+		addr := proxyServer(t, connectServer(test.auth, test.status))
+		greeting, err := dialThrough("http://" + test.user + addr)
+		checkProxyResult(t, test.name, greeting, err, test.wantErr)
+	}
+}
+
+// socksServer speaks SOCKS5, wanting user and password if user isn't
+// empty, and answering the connect request with reply.
+func socksServer(user, password string, reply byte) func(t *testing.T, conn net.Conn) {
+	return func(t *testing.T, conn net.Conn) {
+		r := bufio.NewReader(conn)
+		read := func(n int) []byte {
+			buf := make([]byte, n)
+			if _, err := io.ReadFull(r, buf); err != nil {
+				t.Errorf("proxy: %v", err)
+			}
+			return buf
+		}
+
+		head := read(2)
+		methods := string(read(int(head[1])))
+		method := byte(socksNoAuth)
+		if user != "" {
+			method = socksPasswordAuth
+		}
+		if !strings.ContainsRune(methods, rune(method)) {
+			conn.Write([]byte{socksVersion, socksNoAcceptable})
+			return
+		}
+		conn.Write([]byte{socksVersion, method})
+
+		if method == socksPasswordAuth {
+			head = read(2)
+			gotUser := string(read(int(head[1])))
+			gotPassword := string(read(int(read(1)[0])))
+			if gotUser != user || gotPassword != password {
+				conn.Write([]byte{1, 1})
+				return
+			}
+			conn.Write([]byte{1, 0})
+		}
+
+		head = read(5)
+		if head[1] != socksConnect || head[3] != socksDomain {
+			t.Errorf("proxy got request % x, want a connect to a host name", head)
+			return
+		}
+		host := string(read(int(head[4])))
+		port := binary.BigEndian.Uint16(read(2))
+		if got := net.JoinHostPort(host, fmt.Sprint(port)); got != proxyTarget {
+			t.Errorf("proxy asked for %v, want %v", got, proxyTarget)
+		}
+
+		answer := []byte{socksVersion, reply, 0, socksIPv4, 192, 0, 2, 1, 0x01, 0xbb}
+		if reply == 0 {
+			answer = append(answer, proxyGreeting...)
+		}
+		conn.Write(answer)
+	}
+}
+
+func TestSOCKS5Connect(t *testing.T) {
+	tests := []struct {
+		name           string
+		user           string
+		wantUser, pass string
+		reply          byte
+		wantErr        string
+	}{
+		{"no auth", "", "", "", 0, ""},
+		{"auth", "alice:s3cret@", "alice", "s3cret", 0, ""},
+		{"auth missing", "", "alice", "s3cret", 0, "none of our authentication methods"},
+		{"wrong password", "alice:guess@", "alice", "s3cret", 0, "authentication failed"},
+		{"refused", "", "", "", 5, "SOCKS5 connect to www.example.com:443: connection refused"},
+		{"ruleset", "alice:s3cret@", "alice", "s3cret", 2, "connection not allowed by ruleset"},
+		{"unknown reply", "", "", "", 42, "error 42"},
+	}
+
+	for _, test := range tests {
+		addr := proxyServer(t, socksServer(test.wantUser, test.pass, test.reply))
+		greeting, err := dialThrough("socks5://" + test.user + addr)
+		checkProxyResult(t, test.name, greeting, err, test.wantErr)
+	}
+}
+
+func checkProxyResult(t *testing.T, name, greeting string, err error, wantErr string) {
+	t.Helper()
+	switch {
+	case wantErr == "" && err != nil:
+		t.Errorf("%v: %v", name, err)
+	case wantErr == "" && greeting != proxyGreeting:
+		t.Errorf("%v: target said %q, want %q", name, greeting, proxyGreeting)
+	case wantErr != "" && err == nil:
+		t.Errorf("%v: no error, want one about %q", name, wantErr)
+	case wantErr != "" && !strings.Contains(err.Error(), wantErr):
+		t.Errorf("%v: error %q, want one about %q", name, err, wantErr)
+	case wantErr != "" && strings.Contains(err.Error(), "s3cret"):
+		t.Errorf("%v: error %q gives the password away", name, err)
+	}
+}
+
+func TestNoProxy(t *testing.T) {
+	tests := []struct {
+		noProxy, host string
+		want          bool
+	}{
+		{"example.com", "example.com", true},
+		{"example.com", "www.example.com", true},
+		{"example.com", "badexample.com", false},
+		{"example.com", "example.com.evil.net", false},
+		{".example.com", "www.example.com", true},
+		{".example.com", "example.com", true},
+		{"example.com:8443", "www.example.com", true},
+		{"Example.COM", "WWW.example.com", true},
+		{"other.org, example.com", "www.example.com", true},
+		{"other.org", "www.example.com", false},
+		{"*", "www.example.com", true},
+		{"*", "192.0.2.1", true},
+		{"192.0.2.1", "192.0.2.1", true},
+		{"192.0.2.1:443", "192.0.2.1", true},
+		{"192.0.2.0/24", "192.0.2.77", true},
+		{"192.0.2.0/24", "198.51.100.1", false},
+		{"::1", "::1", true},
+		{"[::1]:443", "::1", true},
+		{"2001:db8::/32", "2001:db8::1", true},
+		{"example.com", "192.0.2.1", false},
+		{"", "www.example.com", false},
+	}
+
+	for _, test := range tests {
+		t.Setenv("NO_PROXY", test.noProxy)
+		if got := noProxy(test.host); got != test.want {
+			t.Errorf("NO_PROXY=%q, %v: got %v, want %v", test.noProxy, test.host, got, test.want)
+		}
+	}
+
+	t.Setenv("NO_PROXY", "")
+	t.Setenv("no_proxy", ".example.com")
+	if !noProxy("www.example.com") {
+		t.Errorf("no_proxy ignored")
+	}
+}
+
+func TestProxyFor(t *testing.T) {
+	t.Setenv("HTTPS_PROXY", "proxy.example.net:3128")
+	t.Setenv("ALL_PROXY", "socks5://socks.example.net")
+	t.Setenv("NO_PROXY", ".internal")
+
+	tests := []struct {
+		flag, host string
+		vars       []string
+		want       string
+	}{
+		{"", "www.example.com", []string{"HTTPS_PROXY", "ALL_PROXY"}, "http://proxy.example.net:3128"},
+		{"", "www.example.com", []string{"HTTP_PROXY", "ALL_PROXY"}, "socks5://socks.example.net:1080"},
+		{"", "db.internal", []string{"HTTPS_PROXY", "ALL_PROXY"}, ""},
+		{"https://other.example.net", "db.internal", []string{"HTTPS_PROXY"}, "https://other.example.net:443"},
+		{"direct", "www.example.com", []string{"HTTPS_PROXY"}, ""},
+	}
+
+	for _, test := range tests {
+		proxy, err := sourceOptions{proxy: test.flag}.proxyFor(test.host, test.vars...)
+		if err != nil {
+			t.Errorf("%v via %q: %v", test.host, test.flag, err)
+			continue
+		}
+		got := ""
+		if proxy != nil {
+			got = proxy.String()
+		}
+		if got != test.want {
+			t.Errorf("%v via %q: got %q, want %q", test.host, test.flag, got, test.want)
+		}
+	}
+
+	if _, err := (sourceOptions{proxy: "ftp://proxy.example.net"}).proxyFor("www.example.com"); err == nil {
+		t.Errorf("ftp proxy accepted")
+	}
+}
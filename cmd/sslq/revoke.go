@@ -0,0 +1,70 @@
+//
+// Copyright (C) 2017 Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+
+	"github.com/zentrope/tools/lib"
+)
+
+// chainRevocation is the revocation check for the cert at Index in
+// the chain.
+type chainRevocation struct {
+	Index   int    `json:"index"`
+	Subject string `json:"subject"`
+	*lib.Revocation
+}
+
+// checkChainRevocation checks every cert in the chain but self-signed
+// roots, using the session's stapled OCSP response for the leaf.
+func checkChainRevocation(certs []*x509.Certificate, session *lib.TLSSession, client *http.Client) []chainRevocation {
+	results := make([]chainRevocation, 0)
+
+	for i, cert := range certs {
+		if bytes.Equal(cert.RawSubject, cert.RawIssuer) {
+			continue
+		}
+
+		var staple []byte
+		if i == 0 && session != nil {
+			staple = session.OCSPResponse
+		}
+
+		results = append(results, chainRevocation{
+			Index:      i,
+			Subject:    cert.Subject.CommonName,
+			Revocation: lib.CheckRevocation(cert, lib.FindIssuer(cert, certs), staple, client),
+		})
+	}
+
+	return results
+}
+
+func revocationProperties(revocations []chainRevocation) *lib.FIFOMap {
+	properties := lib.NewFIFOMap()
+	for _, r := range revocations {
+		props := r.Properties(fmt.Sprintf("revocation.%v", r.Index))
+		for _, k := range props.Keys() {
+			properties.Set(k, props.Get(k))
+		}
+	}
+	return properties
+}
@@ -0,0 +1,298 @@
+//
+// Copyright (C) 2017 Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/zentrope/tools/lib"
+)
+
+var scanVersions = []uint16{
+	tls.VersionTLS10,
+	tls.VersionTLS11,
+	tls.VersionTLS12,
+	tls.VersionTLS13,
+}
+
+type cipherScan struct {
+	Name     string `json:"name"`
+	ID       uint16 `json:"id"`
+	Insecure bool   `json:"insecure"`
+}
+
+type versionScan struct {
+	Version          string       `json:"version"`
+	Supported        bool         `json:"supported"`
+	Deprecated       bool         `json:"deprecated"`
+	ServerPreference bool         `json:"serverPreference"`
+	Ciphers          []cipherScan `json:"ciphers"`
+}
+
+type scanResult struct {
+	Target     string        `json:"target"`
+	Versions   []versionScan `json:"versions"`
+	Deprecated []string      `json:"deprecated"`
+}
+
+// scanner enumerates what a target accepts by handshaking with one
+// version and a restricted set of cipher suites at a time.
+type scanner struct {
+	target string
+	opts   sourceOptions
+}
+
+// try returns the suite the server picks from those offered, or false
+// if the handshake fails.
+func (s *scanner) try(version uint16, suites []uint16) (uint16, bool) {
+	state, err := handshake(s.target, s.opts, func(c *tls.Config) {
+		c.MinVersion = version
+		c.MaxVersion = version
+		c.CipherSuites = suites
+	})
+	if err != nil {
+		return 0, false
+	}
+	return state.CipherSuite, true
+}
+
+// candidates returns every suite the client can offer at version.
+func candidates(version uint16) []*tls.CipherSuite {
+	all := append(tls.CipherSuites(), tls.InsecureCipherSuites()...)
+	suites := make([]*tls.CipherSuite, 0)
+	for _, suite := range all {
+		for _, v := range suite.SupportedVersions {
+			if v == version {
+				suites = append(suites, suite)
+				break
+			}
+		}
+	}
+	return suites
+}
+
+// isInsecure reports suites Go considers insecure, or which lack
+// forward secrecy.
+func isInsecure(suite *tls.CipherSuite) bool {
+	return suite.Insecure || strings.HasPrefix(suite.Name, "TLS_RSA_")
+}
+
+func removeSuite(suites []uint16, id uint16) []uint16 {
+	rest := make([]uint16, 0, len(suites))
+	for _, s := range suites {
+		if s != id {
+			rest = append(rest, s)
+		}
+	}
+	return rest
+}
+
+func (s *scanner) scanVersion(version uint16) versionScan {
+	result := versionScan{
+		Version:    tls.VersionName(version),
+		Deprecated: version < tls.VersionTLS12,
+		Ciphers:    make([]cipherScan, 0),
+	}
+
+	// TLS 1.3 suites aren't configurable, so all we can learn is
+	// which one the server picks.
+	if version == tls.VersionTLS13 {
+		if id, ok := s.try(version, nil); ok {
+			result.Supported = true
+			result.Ciphers = append(result.Ciphers, cipherScan{Name: tls.CipherSuiteName(id), ID: id})
+		}
+		return result
+	}
+
+	byID := make(map[uint16]*tls.CipherSuite)
+	accepted := make([]uint16, 0)
+	for _, suite := range candidates(version) {
+		byID[suite.ID] = suite
+		if _, ok := s.try(version, []uint16{suite.ID}); ok {
+			accepted = append(accepted, suite.ID)
+		}
+	}
+
+	if len(accepted) == 0 {
+		return result
+	}
+	result.Supported = true
+
+	// If the server picks the same suite no matter the order offered,
+	// it's enforcing its own preference, which we can then recover by
+	// offering what's left after each pick.
+	if len(accepted) > 1 {
+		reversed := make([]uint16, len(accepted))
+		for i, id := range accepted {
+			reversed[len(accepted)-1-i] = id
+		}
+		first, ok1 := s.try(version, accepted)
+		second, ok2 := s.try(version, reversed)
+		result.ServerPreference = ok1 && ok2 && first == second
+	}
+
+	ordered := accepted
+	if result.ServerPreference {
+		ordered = make([]uint16, 0, len(accepted))
+		remaining := accepted
+		for len(remaining) > 0 {
+			id, ok := s.try(version, remaining)
+			if !ok {
+				ordered = append(ordered, remaining...)
+				break
+			}
+			ordered = append(ordered, id)
+			remaining = removeSuite(remaining, id)
+		}
+	}
+
+	for _, id := range ordered {
+		suite := byID[id]
+		result.Ciphers = append(result.Ciphers, cipherScan{
+			Name:     suite.Name,
+			ID:       id,
+			Insecure: isInsecure(suite),
+		})
+	}
+
+	return result
+}
+
+func (s *scanner) scan() scanResult {
+	result := scanResult{
+		Target:     s.target,
+		Versions:   make([]versionScan, 0),
+		Deprecated: make([]string, 0),
+	}
+
+	for _, version := range scanVersions {
+		v := s.scanVersion(version)
+		result.Versions = append(result.Versions, v)
+
+		if !v.Supported {
+			continue
+		}
+		if v.Deprecated {
+			result.Deprecated = append(result.Deprecated, v.Version)
+		}
+		for _, c := range v.Ciphers {
+			if c.Insecure {
+				result.Deprecated = append(result.Deprecated, v.Version+" "+c.Name)
+			}
+		}
+	}
+
+	return result
+}
+
+// Properties returns the scan as "scan." keys to values.
+func (r scanResult) Properties() *lib.FIFOMap {
+	properties := lib.NewFIFOMap()
+
+	properties.Set("scan.target", r.Target)
+
+	for _, v := range r.Versions {
+		prefix := "scan." + strings.ToLower(strings.Replace(v.Version, " ", "", -1))
+
+		properties.Set(prefix+".supported", fmt.Sprintf("%v", v.Supported))
+		if !v.Supported {
+			continue
+		}
+		properties.Set(prefix+".deprecated", fmt.Sprintf("%v", v.Deprecated))
+		properties.Set(prefix+".server.preference", fmt.Sprintf("%v", v.ServerPreference))
+		for i, c := range v.Ciphers {
+			value := c.Name
+			if c.Insecure {
+				value += " (insecure)"
+			}
+			properties.Set(fmt.Sprintf("%v.ciphers.%v", prefix, i), value)
+		}
+	}
+
+	properties.Set("scan.deprecated", strings.Join(r.Deprecated, ", "))
+
+	return properties
+}
+
+func scanUsage(fs *flag.FlagSet) func() {
+	return func() {
+		fmt.Printf("USAGE: ssql scan [options] host[:port] [text|json]\n\n")
+		fmt.Println("Enumerate the TLS versions and cipher suites a server accepts, in")
+		fmt.Println("its order of preference, flagging deprecated ones.")
+		fmt.Println("")
+		fmt.Println("OPTIONS:")
+		fs.PrintDefaults()
+	}
+}
+
+func scanMain(args []string) int {
+	var opts sourceOptions
+
+	fs := flag.NewFlagSet("scan", flag.ExitOnError)
+	opts.addFlags(fs)
+	fs.Usage = scanUsage(fs)
+	fs.Parse(args)
+
+	if err := opts.loadClientCert(); err != nil {
+		fmt.Printf("ERROR: %v\n", err)
+		return 1
+	}
+
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return 1
+	}
+
+	target := fs.Arg(0)
+	format := "text"
+	if fs.NArg() > 1 {
+		format = fs.Arg(1)
+	}
+
+	if format != "text" && format != "json" {
+		fmt.Printf("ERROR: Unrecognized output format: '%v'.\n", format)
+		return 1
+	}
+
+	// Make sure the target is there at all before blaming failures on
+	// unsupported versions or suites.
+	if _, _, err := getStateFromNet(target, opts); err != nil {
+		fmt.Printf("ERROR: %v\n", err)
+		return 1
+	}
+
+	s := &scanner{target: target, opts: opts}
+	result := s.scan()
+
+	if format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(result)
+		return 0
+	}
+
+	props := result.Properties()
+	for _, k := range props.Keys() {
+		fmt.Printf("%-35v = %v\n", k, props.Get(k))
+	}
+	return 0
+}
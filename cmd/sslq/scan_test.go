@@ -0,0 +1,146 @@
+//
+// Copyright (C) 2017 Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scanServer scans a test server restricted to the versions and suites
+// given.
+func scanServer(t *testing.T, min, max uint16, suites ...uint16) scanResult {
+	t.Helper()
+	server := httptest.NewUnstartedServer(http.NotFoundHandler())
+	server.TLS = &tls.Config{MinVersion: min, MaxVersion: max, CipherSuites: suites}
+	server.Config.ErrorLog = log.New(ioutil.Discard, "", 0) // every refused handshake
+	server.StartTLS()
+	defer server.Close()
+
+	opts := sourceOptions{proto: "tls", proxy: "direct", timeout: 5 * time.Second}
+	s := &scanner{target: server.Listener.Addr().String(), opts: opts}
+	return s.scan()
+}
+
+func supported(r scanResult) []string {
+	versions := make([]string, 0)
+	for _, v := range r.Versions {
+		if v.Supported {
+			versions = append(versions, v.Version)
+		}
+	}
+	return versions
+}
+
+func cipherNames(v versionScan) []string {
+	names := make([]string, 0)
+	for _, c := range v.Ciphers {
+		names = append(names, c.Name)
+	}
+	return names
+}
+
+func TestScanTLS12(t *testing.T) {
+	suites := []uint16{
+		tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	}
+	r := scanServer(t, tls.VersionTLS12, tls.VersionTLS12, suites...)
+
+	if got := strings.Join(supported(r), ", "); got != "TLS 1.2" {
+		t.Fatalf("supported %v, want TLS 1.2", got)
+	}
+	if len(r.Versions) != len(scanVersions) {
+		t.Errorf("%v versions scanned, want %v", len(r.Versions), len(scanVersions))
+	}
+
+	v := r.Versions[2]
+	got := cipherNames(v)
+	sorted := append([]string{}, got...)
+	sort.Strings(sorted)
+	want := []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+		"TLS_RSA_WITH_AES_128_GCM_SHA256"}
+	if strings.Join(sorted, ",") != strings.Join(want, ",") {
+		t.Errorf("ciphers %v, want %v", got, want)
+	}
+
+	// Go servers pick from their own preference order, forward
+	// secrecy first, whatever the client offers.
+	if !v.ServerPreference {
+		t.Errorf("server preference not detected")
+	}
+	if len(got) == 3 && got[2] != "TLS_RSA_WITH_AES_128_GCM_SHA256" {
+		t.Errorf("preference order %v, want TLS_RSA_WITH_AES_128_GCM_SHA256 last", got)
+	}
+
+	for _, c := range v.Ciphers {
+		if c.Insecure != strings.HasPrefix(c.Name, "TLS_RSA_") {
+			t.Errorf("%v: insecure %v", c.Name, c.Insecure)
+		}
+	}
+	if got := strings.Join(r.Deprecated, ", "); got != "TLS 1.2 TLS_RSA_WITH_AES_128_GCM_SHA256" {
+		t.Errorf("deprecated %q, want just the RSA key exchange suite", got)
+	}
+
+	props := r.Properties()
+	if props.Get("scan.tls1.2.supported") != "true" || props.Get("scan.tls1.0.supported") != "false" ||
+		props.Get("scan.tls1.2.server.preference") != "true" {
+		t.Errorf("properties: %v", props.Keys())
+	}
+}
+
+func TestScanTLS13(t *testing.T) {
+	r := scanServer(t, tls.VersionTLS13, tls.VersionTLS13)
+
+	if got := strings.Join(supported(r), ", "); got != "TLS 1.3" {
+		t.Fatalf("supported %v, want TLS 1.3", got)
+	}
+	v := r.Versions[3]
+	if len(v.Ciphers) != 1 || !strings.HasPrefix(v.Ciphers[0].Name, "TLS_AES_") &&
+		!strings.HasPrefix(v.Ciphers[0].Name, "TLS_CHACHA20_") {
+		t.Errorf("ciphers %v, want the one TLS 1.3 suite picked", cipherNames(v))
+	}
+	if len(r.Deprecated) != 0 {
+		t.Errorf("deprecated %v, want none", r.Deprecated)
+	}
+}
+
+func TestScanDeprecatedVersions(t *testing.T) {
+	r := scanServer(t, tls.VersionTLS10, tls.VersionTLS11, tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA)
+
+	if got := strings.Join(supported(r), ", "); got != "TLS 1.0, TLS 1.1" {
+		t.Fatalf("supported %v, want TLS 1.0, TLS 1.1", got)
+	}
+	for _, v := range r.Versions[:2] {
+		if !v.Deprecated || v.ServerPreference ||
+			strings.Join(cipherNames(v), ",") != "TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA" {
+			t.Errorf("%v: deprecated %v, preference %v, ciphers %v", v.Version, v.Deprecated,
+				v.ServerPreference, cipherNames(v))
+		}
+	}
+	if got := strings.Join(r.Deprecated, ", "); got != "TLS 1.0, TLS 1.1" {
+		t.Errorf("deprecated %q, want TLS 1.0, TLS 1.1", got)
+	}
+}
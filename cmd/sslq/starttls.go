@@ -0,0 +1,353 @@
+//
+// Copyright (C) 2017 Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+)
+
+// A preamble speaks just enough of a plaintext protocol to get the
+// server to the point where it expects a TLS client hello.
+type preamble func(conn net.Conn, host string) error
+
+type protocol struct {
+	port     string
+	preamble preamble
+}
+
+var protocols = map[string]protocol{
+	"tls":      {"443", nil},
+	"smtp":     {"25", smtpPreamble},
+	"imap":     {"143", imapPreamble},
+	"pop3":     {"110", pop3Preamble},
+	"ftp":      {"21", ftpPreamble},
+	"xmpp":     {"5222", xmppPreamble},
+	"ldap":     {"389", ldapPreamble},
+	"postgres": {"5432", postgresPreamble},
+}
+
+func protocolNames() []string {
+	names := make([]string, 0)
+	for name := range protocols {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseTarget turns "host", "host:port" or "proto://host:port" into
+// a protocol name, the bare host name and a dialable address, using
+// the protocol's well known port if none is given.
+func parseTarget(target, proto string) (string, string, string, error) {
+
+	if i := strings.Index(target, "://"); i != -1 {
+		proto = target[:i]
+		target = target[i+3:]
+	}
+
+	p, ok := protocols[proto]
+	if !ok {
+		return "", "", "", fmt.Errorf("unknown protocol '%v' (try: %v)", proto,
+			strings.Join(protocolNames(), ", "))
+	}
+
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		// No port, or a bare IPv6 address.
+		host = strings.Trim(target, "[]")
+		port = p.port
+	}
+
+	if host == "" {
+		return "", "", "", fmt.Errorf("no host in '%v'", target)
+	}
+
+	return proto, host, net.JoinHostPort(host, port), nil
+}
+
+//-----------------------------------------------------------------------------
+// Line oriented protocols
+//-----------------------------------------------------------------------------
+
+func send(conn net.Conn, line string) error {
+	_, err := io.WriteString(conn, line+"\r\n")
+	return err
+}
+
+// readCodeReply reads an SMTP or FTP style reply ("250-more",
+// "250 last") and checks the status code.
+func readCodeReply(r *bufio.Reader, code string) error {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		if len(line) < 4 || !strings.HasPrefix(line, code) {
+			return fmt.Errorf("expected %v, got: %v", code, strings.TrimSpace(line))
+		}
+		if line[3] == ' ' {
+			return nil
+		}
+	}
+}
+
+func smtpPreamble(conn net.Conn, host string) error {
+	r := bufio.NewReader(conn)
+
+	if err := readCodeReply(r, "220"); err != nil {
+		return err
+	}
+	if err := send(conn, "EHLO sslq"); err != nil {
+		return err
+	}
+	if err := readCodeReply(r, "250"); err != nil {
+		return err
+	}
+	if err := send(conn, "STARTTLS"); err != nil {
+		return err
+	}
+	return readCodeReply(r, "220")
+}
+
+func ftpPreamble(conn net.Conn, host string) error {
+	r := bufio.NewReader(conn)
+
+	if err := readCodeReply(r, "220"); err != nil {
+		return err
+	}
+	if err := send(conn, "AUTH TLS"); err != nil {
+		return err
+	}
+	return readCodeReply(r, "234")
+}
+
+func imapPreamble(conn net.Conn, host string) error {
+	r := bufio.NewReader(conn)
+
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "* OK") {
+		return fmt.Errorf("unexpected greeting: %v", strings.TrimSpace(line))
+	}
+
+	if err := send(conn, "a1 STARTTLS"); err != nil {
+		return err
+	}
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(line, "a1 ") {
+			if !strings.HasPrefix(line, "a1 OK") {
+				return fmt.Errorf("STARTTLS refused: %v", strings.TrimSpace(line))
+			}
+			return nil
+		}
+	}
+}
+
+func pop3Preamble(conn net.Conn, host string) error {
+	r := bufio.NewReader(conn)
+
+	for _, cmd := range []string{"", "STLS"} {
+		if cmd != "" {
+			if err := send(conn, cmd); err != nil {
+				return err
+			}
+		}
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(line, "+OK") {
+			return fmt.Errorf("expected +OK, got: %v", strings.TrimSpace(line))
+		}
+	}
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// XMPP
+//-----------------------------------------------------------------------------
+
+// readUntil reads from r until one of the markers is seen and returns
+// the marker found.
+func readUntil(r *bufio.Reader, markers ...string) (string, error) {
+	var seen strings.Builder
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		seen.WriteByte(b)
+		for _, m := range markers {
+			if strings.HasSuffix(seen.String(), m) {
+				return m, nil
+			}
+		}
+	}
+}
+
+func xmppPreamble(conn net.Conn, host string) error {
+	r := bufio.NewReader(conn)
+
+	header := fmt.Sprintf("<?xml version='1.0'?><stream:stream to='%v' "+
+		"xmlns='jabber:client' xmlns:stream='http://etherx.jabber.org/streams' "+
+		"version='1.0'>", host)
+
+	if _, err := io.WriteString(conn, header); err != nil {
+		return err
+	}
+
+	if _, err := readUntil(r, "</stream:features>"); err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(conn, "<starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>"); err != nil {
+		return err
+	}
+
+	m, err := readUntil(r, "<proceed", "<failure")
+	if err != nil {
+		return err
+	}
+	if m != "<proceed" {
+		return errors.New("STARTTLS refused by XMPP server")
+	}
+
+	// Consume the rest of the <proceed .../> element.
+	_, err = readUntil(r, ">")
+	return err
+}
+
+//-----------------------------------------------------------------------------
+// LDAP
+//-----------------------------------------------------------------------------
+
+// The StartTLS extended request (RFC 4511, 4.14.1) as message ID 1.
+var ldapStartTLS = []byte{
+	0x30, 0x1d, 0x02, 0x01, 0x01, 0x77, 0x18, 0x80, 0x16,
+	'1', '.', '3', '.', '6', '.', '1', '.', '4', '.', '1', '.',
+	'1', '4', '6', '6', '.', '2', '0', '0', '3', '7',
+}
+
+// maxBERLength is the longest element readBER accepts. A StartTLS
+// response is a few dozen bytes, so anything near this is a server
+// which isn't speaking LDAP.
+const maxBERLength = 64 * 1024
+
+// readBER reads a single BER encoded element (tag, length, content).
+func readBER(r io.Reader) ([]byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	length := int(header[1])
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 || n > 4 {
+			return nil, errors.New("unsupported BER length")
+		}
+		lb := make([]byte, n)
+		if _, err := io.ReadFull(r, lb); err != nil {
+			return nil, err
+		}
+		header = append(header, lb...)
+		length = 0
+		for _, b := range lb {
+			length = length<<8 | int(b)
+		}
+	}
+	if length > maxBERLength {
+		return nil, fmt.Errorf("BER length %v too long (more than %v)", length, maxBERLength)
+	}
+
+	content := make([]byte, length)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return nil, err
+	}
+	return append(header, content...), nil
+}
+
+func ldapPreamble(conn net.Conn, host string) error {
+	if _, err := conn.Write(ldapStartTLS); err != nil {
+		return err
+	}
+
+	msg, err := readBER(conn)
+	if err != nil {
+		return err
+	}
+
+	var response struct {
+		ID int
+		Op asn1.RawValue
+	}
+	if _, err := asn1.Unmarshal(msg, &response); err != nil {
+		return fmt.Errorf("bad LDAP response: %v", err)
+	}
+
+	// ExtendedResponse is [APPLICATION 24].
+	if response.Op.Class != asn1.ClassApplication || response.Op.Tag != 24 {
+		return fmt.Errorf("unexpected LDAP response (tag %v)", response.Op.Tag)
+	}
+
+	var code asn1.Enumerated
+	if _, err := asn1.Unmarshal(response.Op.Bytes, &code); err != nil {
+		return fmt.Errorf("bad LDAP result code: %v", err)
+	}
+	if code != 0 {
+		return fmt.Errorf("StartTLS refused by LDAP server (result code %v)", code)
+	}
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// Postgres
+//-----------------------------------------------------------------------------
+
+func postgresPreamble(conn net.Conn, host string) error {
+	request := make([]byte, 8)
+	binary.BigEndian.PutUint32(request[0:4], 8)
+	binary.BigEndian.PutUint32(request[4:8], 80877103)
+
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	answer := make([]byte, 1)
+	if _, err := io.ReadFull(conn, answer); err != nil {
+		return err
+	}
+	if answer[0] != 'S' {
+		return errors.New("server does not support SSL")
+	}
+	return nil
+}
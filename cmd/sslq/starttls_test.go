@@ -0,0 +1,226 @@
+//
+// Copyright (C) 2017 Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestParseTarget(t *testing.T) {
+	tests := []struct {
+		target, proto         string
+		wantProto, host, addr string
+		wantErr               bool
+	}{
+		{"example.com", "tls", "tls", "example.com", "example.com:443", false},
+		{"example.com:8443", "tls", "tls", "example.com", "example.com:8443", false},
+		{"mail.example.com", "smtp", "smtp", "mail.example.com", "mail.example.com:25", false},
+		{"smtp://mail.example.com", "tls", "smtp", "mail.example.com", "mail.example.com:25", false},
+		{"imap://mail.example.com:1143", "tls", "imap", "mail.example.com", "mail.example.com:1143", false},
+		{"pop3://mail.example.com", "tls", "pop3", "mail.example.com", "mail.example.com:110", false},
+		{"ftp://files.example.com", "tls", "ftp", "files.example.com", "files.example.com:21", false},
+		{"xmpp://chat.example.com", "tls", "xmpp", "chat.example.com", "chat.example.com:5222", false},
+		{"ldap://dir.example.com", "tls", "ldap", "dir.example.com", "dir.example.com:389", false},
+		{"postgres://db.example.com", "tls", "postgres", "db.example.com", "db.example.com:5432", false},
+		{"[::1]:636", "ldap", "ldap", "::1", "[::1]:636", false},
+		{"[::1]", "postgres", "postgres", "::1", "[::1]:5432", false},
+		{"::1", "tls", "tls", "::1", "[::1]:443", false},
+		{"smtp://[2001:db8::1]:587", "tls", "smtp", "2001:db8::1", "[2001:db8::1]:587", false},
+		{"gopher://example.com", "tls", "", "", "", true},
+		{"example.com", "gopher", "", "", "", true},
+		{"smtp://", "tls", "", "", "", true},
+		{":25", "smtp", "", "", "", true},
+	}
+
+	for _, test := range tests {
+		proto, host, addr, err := parseTarget(test.target, test.proto)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("parseTarget(%q, %q): no error, want one", test.target, test.proto)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseTarget(%q, %q): %v", test.target, test.proto, err)
+			continue
+		}
+		if proto != test.wantProto || host != test.host || addr != test.addr {
+			t.Errorf("parseTarget(%q, %q) = %q, %q, %q, want %q, %q, %q", test.target, test.proto,
+				proto, host, addr, test.wantProto, test.host, test.addr)
+		}
+	}
+}
+
+// A standIn is the server end of a preamble.
+type standIn func(t *testing.T, conn net.Conn)
+
+// runPreamble runs the preamble against the stand-in server over a
+// pipe, returning the preamble's error once the server has finished.
+func runPreamble(t *testing.T, p preamble, server standIn) error {
+	client, conn := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer conn.Close()
+		server(t, conn)
+	}()
+
+	err := p(client, "example.com")
+	client.Close()
+	<-done
+	return err
+}
+
+// lineServer sends the greeting, then answers each expected line from
+// the client with its reply.
+func lineServer(greeting string, dialogue ...string) standIn {
+	return func(t *testing.T, conn net.Conn) {
+		r := bufio.NewReader(conn)
+		if greeting != "" {
+			io.WriteString(conn, greeting)
+		}
+		for i := 0; i+1 < len(dialogue); i += 2 {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if got := strings.TrimRight(line, "\r\n"); got != dialogue[i] {
+				t.Errorf("server got %q, want %q", got, dialogue[i])
+				return
+			}
+			io.WriteString(conn, dialogue[i+1])
+		}
+	}
+}
+
+func xmppServer(answer string) standIn {
+	return func(t *testing.T, conn net.Conn) {
+		r := bufio.NewReader(conn)
+		if _, err := readUntil(r, "version='1.0'>"); err != nil {
+			return
+		}
+		io.WriteString(conn, "<?xml version='1.0'?><stream:stream from='example.com' "+
+			"xmlns='jabber:client' xmlns:stream='http://etherx.jabber.org/streams' version='1.0'>"+
+			"<stream:features><starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'><required/></starttls>"+
+			"</stream:features>")
+		if _, err := readUntil(r, "/>"); err != nil {
+			return
+		}
+		io.WriteString(conn, answer)
+	}
+}
+
+// ldapServer answers the StartTLS request with an extended response
+// carrying the result code.
+func ldapServer(code byte) standIn {
+	return func(t *testing.T, conn net.Conn) {
+		msg, err := readBER(conn)
+		if err != nil {
+			return
+		}
+		if !bytes.Equal(msg, ldapStartTLS) {
+			t.Errorf("server got % x, want the StartTLS request", msg)
+			return
+		}
+		conn.Write([]byte{
+			0x30, 0x0c, 0x02, 0x01, 0x01,
+			0x78, 0x07, 0x0a, 0x01, code, 0x04, 0x00, 0x04, 0x00,
+		})
+	}
+}
+
+// rawServer reads whatever the client sends first, then answers with
+// reply.
+func rawServer(reply []byte) standIn {
+	return func(t *testing.T, conn net.Conn) {
+		if _, err := conn.Read(make([]byte, 512)); err != nil {
+			return
+		}
+		conn.Write(reply)
+	}
+}
+
+func postgresServer(answer byte) standIn {
+	return func(t *testing.T, conn net.Conn) {
+		request := make([]byte, 8)
+		if _, err := io.ReadFull(conn, request); err != nil {
+			return
+		}
+		if binary.BigEndian.Uint32(request[0:4]) != 8 || binary.BigEndian.Uint32(request[4:8]) != 80877103 {
+			t.Errorf("server got % x, want an SSLRequest", request)
+			return
+		}
+		conn.Write([]byte{answer})
+	}
+}
+
+func TestPreambles(t *testing.T) {
+	tests := []struct {
+		name     string
+		preamble preamble
+		server   standIn
+		wantErr  string
+	}{
+		{"smtp", smtpPreamble, lineServer("220 mail.example.com ESMTP\r\n",
+			"EHLO sslq", "250-mail.example.com\r\n250-PIPELINING\r\n250 STARTTLS\r\n",
+			"STARTTLS", "220 2.0.0 Ready to start TLS\r\n"), ""},
+		{"smtp refused", smtpPreamble, lineServer("220 mail.example.com ESMTP\r\n",
+			"EHLO sslq", "250 mail.example.com\r\n",
+			"STARTTLS", "454 4.7.0 TLS not available\r\n"), "454"},
+		{"smtp busy", smtpPreamble, lineServer("554 no service\r\n"), "554"},
+		{"ftp", ftpPreamble, lineServer("220 FTP ready\r\n",
+			"AUTH TLS", "234 AUTH TLS successful\r\n"), ""},
+		{"ftp refused", ftpPreamble, lineServer("220 FTP ready\r\n",
+			"AUTH TLS", "502 Command not implemented\r\n"), "502"},
+		{"imap", imapPreamble, lineServer("* OK IMAP4rev1 ready\r\n",
+			"a1 STARTTLS", "* CAPABILITY IMAP4rev1\r\na1 OK Begin TLS negotiation now\r\n"), ""},
+		{"imap refused", imapPreamble, lineServer("* OK IMAP4rev1 ready\r\n",
+			"a1 STARTTLS", "a1 NO STARTTLS not available\r\n"), "a1 NO"},
+		{"imap bad greeting", imapPreamble, lineServer("* BYE go away\r\n"), "BYE"},
+		{"pop3", pop3Preamble, lineServer("+OK POP3 ready\r\n",
+			"STLS", "+OK Begin TLS negotiation\r\n"), ""},
+		{"pop3 refused", pop3Preamble, lineServer("+OK POP3 ready\r\n",
+			"STLS", "-ERR Command not permitted\r\n"), "-ERR"},
+		{"xmpp", xmppPreamble, xmppServer("<proceed xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>"), ""},
+		{"xmpp refused", xmppPreamble, xmppServer("<failure xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>"), "refused"},
+		{"ldap", ldapPreamble, ldapServer(0), ""},
+		{"ldap refused", ldapPreamble, ldapServer(2), "result code 2"},
+		{"ldap oversized", ldapPreamble, rawServer([]byte{0x30, 0x84, 0xff, 0xff, 0xff, 0xff}), "too long"},
+		{"ldap just too long", ldapPreamble, rawServer([]byte{0x30, 0x83, 0x01, 0x00, 0x01}), "too long"},
+		{"ldap long length", ldapPreamble, rawServer([]byte{0x30, 0x85, 0, 0, 0, 0, 1}), "unsupported BER length"},
+		{"postgres", postgresPreamble, postgresServer('S'), ""},
+		{"postgres refused", postgresPreamble, postgresServer('N'), "does not support SSL"},
+	}
+
+	for _, test := range tests {
+		err := runPreamble(t, test.preamble, test.server)
+		switch {
+		case test.wantErr == "" && err != nil:
+			t.Errorf("%v: %v", test.name, err)
+		case test.wantErr != "" && err == nil:
+			t.Errorf("%v: no error, want one about %q", test.name, test.wantErr)
+		case test.wantErr != "" && !strings.Contains(err.Error(), test.wantErr):
+			t.Errorf("%v: error %q, want one about %q", test.name, err, test.wantErr)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+//
+// Copyright (C) 2017 Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/zentrope/tools/lib"
+)
+
+// verifyFlags control how the leaf cert is verified.
+type verifyFlags struct {
+	caFile   string
+	hostname string
+	at       string
+	ekus     string
+}
+
+func (v *verifyFlags) addFlags(fs *flag.FlagSet) {
+	fs.StringVar(&v.caFile, "cafile", "", "Verify against the roots in this file instead of the system's.")
+	fs.StringVar(&v.hostname, "hostname", "", "Verify the cert is valid for this host name.")
+	fs.StringVar(&v.at, "at", "", "Verify as of this time (RFC 3339 or YYYY-MM-DD) instead of now.")
+	fs.StringVar(&v.ekus, "eku", "", "Comma separated extended key usages required (default serverAuth).")
+}
+
+// parseTime accepts an RFC 3339 timestamp or a plain (UTC) date.
+func parseTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return t, fmt.Errorf("bad time '%v' (use RFC 3339 or YYYY-MM-DD)", value)
+	}
+	return t, nil
+}
+
+// options turns the flags into x509 verify options.
+func (v verifyFlags) options() (x509.VerifyOptions, error) {
+	opts := x509.VerifyOptions{DNSName: v.hostname}
+
+	if v.caFile != "" {
+		contents, err := ioutil.ReadFile(v.caFile)
+		if err != nil {
+			return opts, err
+		}
+		roots, err := lib.ParseCertificates(contents, "")
+		if err != nil {
+			return opts, fmt.Errorf("%v: %v", v.caFile, err)
+		}
+		opts.Roots = x509.NewCertPool()
+		for _, root := range roots {
+			opts.Roots.AddCert(root)
+		}
+	}
+
+	if v.at != "" {
+		t, err := parseTime(v.at)
+		if err != nil {
+			return opts, err
+		}
+		opts.CurrentTime = t
+	}
+
+	if v.ekus != "" {
+		for _, name := range strings.Split(v.ekus, ",") {
+			eku, err := lib.ParseExtKeyUsage(strings.TrimSpace(name))
+			if err != nil {
+				return opts, err
+			}
+			opts.KeyUsages = append(opts.KeyUsages, eku)
+		}
+	}
+
+	return opts, nil
+}
@@ -0,0 +1,156 @@
+//
+// Copyright (C) 2017 Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package lib
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// maxChainLength bounds the issuers followed, in case of loops.
+const maxChainLength = 10
+
+// AIAFetcher downloads the issuer certs named in a cert's Authority
+// Information Access extension, keeping them in CacheDir (if set) so
+// each URL is only fetched once. Roots (the system roots if nil) are
+// where the chain ends.
+type AIAFetcher struct {
+	Client   *http.Client
+	CacheDir string
+	Roots    *x509.CertPool
+}
+
+// issuedByRoot reports whether cert is signed by one of the roots.
+func (f *AIAFetcher) issuedByRoot(cert *x509.Certificate) bool {
+	roots := f.Roots
+	if roots == nil {
+		var err error
+		if roots, err = x509.SystemCertPool(); err != nil {
+			return false
+		}
+	}
+	_, err := cert.Verify(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err == nil
+}
+
+// Fetch returns the certs at url, from the cache if it's there.
+func (f *AIAFetcher) Fetch(url string) ([]*x509.Certificate, error) {
+	var path string
+	if f.CacheDir != "" {
+		sum := sha256.Sum256([]byte(url))
+		path = filepath.Join(f.CacheDir, hex.EncodeToString(sum[:]))
+		if data, err := ioutil.ReadFile(path); err == nil {
+			return ParseCertificates(data, "")
+		}
+	}
+
+	resp, err := f.Client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%v: %v", url, resp.Status)
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// Usually DER, sometimes a PKCS#7 bundle.
+	certs, err := ParseCertificates(data, "")
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", url, err)
+	}
+
+	if path != "" {
+		if err := os.MkdirAll(f.CacheDir, 0755); err == nil {
+			ioutil.WriteFile(path, data, 0644)
+		}
+	}
+
+	return certs, nil
+}
+
+// CompleteChain orders certs from the leaf (the first) up to, but not
+// including, its root, fetching any issuers that are missing. Certs
+// not part of the leaf's chain are dropped. The chain is complete
+// once a cert is issued by one of the roots. Problems fetching are
+// returned along with as much of the chain as could be built.
+func (f *AIAFetcher) CompleteChain(certs []*x509.Certificate) ([]*x509.Certificate, []error) {
+	errs := make([]error, 0)
+	if len(certs) == 0 {
+		return certs, errs
+	}
+
+	chain := []*x509.Certificate{certs[0]}
+
+	for len(chain) < maxChainLength {
+		cert := chain[len(chain)-1]
+		if bytes.Equal(cert.RawIssuer, cert.RawSubject) {
+			break
+		}
+
+		issuer := FindIssuer(cert, certs)
+		if issuer == nil && f.issuedByRoot(cert) {
+			break
+		}
+
+		// Failures only matter if no URL has the issuer.
+		tried := make([]error, 0)
+		for _, url := range cert.IssuingCertificateURL {
+			if issuer != nil {
+				break
+			}
+			fetched, err := f.Fetch(url)
+			if err != nil {
+				tried = append(tried, err)
+				continue
+			}
+			if issuer = FindIssuer(cert, fetched); issuer == nil {
+				tried = append(tried, fmt.Errorf("%v: not the issuer of %v", url, CertName(cert)))
+			}
+		}
+
+		if issuer == nil {
+			errs = append(errs, tried...)
+			errs = append(errs, fmt.Errorf("issuer of %v not found", CertName(cert)))
+			break
+		}
+
+		// Roots are in the trust store, not the chain.
+		if bytes.Equal(issuer.RawIssuer, issuer.RawSubject) {
+			break
+		}
+
+		chain = append(chain, issuer)
+	}
+
+	return chain, errs
+}
@@ -0,0 +1,197 @@
+//
+// Copyright (C) 2017 Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package lib
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testPKCS7 wraps certs in a degenerate PKCS#7 SignedData, as served
+// from .p7c URLs.
+func testPKCS7(t *testing.T, certs ...*x509.Certificate) []byte {
+	t.Helper()
+
+	raw := make([]byte, 0)
+	for _, c := range certs {
+		raw = append(raw, c.Raw...)
+	}
+	emptySet := asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true}
+
+	signed, err := asn1.Marshal(struct {
+		Version          int
+		DigestAlgorithms asn1.RawValue
+		ContentInfo      struct{ ContentType asn1.ObjectIdentifier }
+		Certificates     asn1.RawValue
+		SignerInfos      asn1.RawValue
+	}{
+		Version:          1,
+		DigestAlgorithms: emptySet,
+		ContentInfo:      struct{ ContentType asn1.ObjectIdentifier }{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: raw},
+		SignerInfos:      emptySet,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := asn1.Marshal(struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue
+	}{oidSignedData, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signed}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+// aiaServer serves certs by path, counting the requests for each.
+type aiaServer struct {
+	*httptest.Server
+	mu    sync.Mutex
+	files map[string][]byte
+	hits  map[string]int
+}
+
+func newAIAServer() *aiaServer {
+	s := &aiaServer{files: make(map[string][]byte), hits: make(map[string]int)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.hits[r.URL.Path]++
+		data, ok := s.files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+	return s
+}
+
+func (s *aiaServer) count(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hits[path]
+}
+
+func names(certs []*x509.Certificate) string {
+	names := make([]string, 0, len(certs))
+	for _, c := range certs {
+		names = append(names, CertName(c))
+	}
+	return strings.Join(names, ", ")
+}
+
+func TestCompleteChain(t *testing.T) {
+	server := newAIAServer()
+	defer server.Close()
+
+	root := newTestCA(t, "Test Root", nil, "")
+	intermediate := newTestCA(t, "Test Intermediate", root, "")
+	unrelated := newTestCA(t, "Unrelated CA", nil, "")
+
+	server.files["/int.der"] = intermediate.Cert.Raw
+	server.files["/int.p7c"] = testPKCS7(t, intermediate.Cert)
+
+	leafFrom := func(path string) *x509.Certificate {
+		return intermediate.issue(t, "www.example.com", func(c *x509.Certificate) {
+			c.IssuingCertificateURL = []string{server.URL + path}
+		})
+	}
+	derLeaf := leafFrom("/int.der")
+	p7Leaf := leafFrom("/int.p7c")
+	orphan := leafFrom("/missing.der")
+	bare := intermediate.issue(t, "bare.example.com", nil)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root.Cert)
+
+	tests := []struct {
+		name  string
+		certs []*x509.Certificate
+		want  string
+		errs  string
+	}{
+		{"fetched as DER", []*x509.Certificate{derLeaf}, "www.example.com, Test Intermediate", ""},
+		{"fetched as PKCS#7", []*x509.Certificate{p7Leaf}, "www.example.com, Test Intermediate", ""},
+		{"sent, out of order, with unrelated certs",
+			[]*x509.Certificate{bare, unrelated.Cert, root.Cert, intermediate.Cert},
+			"bare.example.com, Test Intermediate", ""},
+		{"intermediate without a URL issued by a root",
+			[]*x509.Certificate{bare, intermediate.Cert}, "bare.example.com, Test Intermediate", ""},
+		{"leaf issued by a root", []*x509.Certificate{root.issue(t, "direct.example.com", nil)},
+			"direct.example.com", ""},
+		{"issuer not served", []*x509.Certificate{orphan}, "www.example.com", "404"},
+		{"no URL and no issuer", []*x509.Certificate{bare}, "bare.example.com", "issuer of bare.example.com not found"},
+	}
+
+	for _, test := range tests {
+		fetcher := &AIAFetcher{Client: server.Client(), Roots: roots}
+		chain, errs := fetcher.CompleteChain(test.certs)
+
+		if got := names(chain); got != test.want {
+			t.Errorf("%v: chain %v, want %v", test.name, got, test.want)
+		}
+
+		messages := make([]string, 0)
+		for _, err := range errs {
+			messages = append(messages, err.Error())
+		}
+		got := strings.Join(messages, "; ")
+		switch {
+		case test.errs == "" && got != "":
+			t.Errorf("%v: unexpected errors: %v", test.name, got)
+		case !strings.Contains(got, test.errs):
+			t.Errorf("%v: errors %q, want one about %q", test.name, got, test.errs)
+		}
+	}
+}
+
+func TestAIAFetcherCache(t *testing.T) {
+	server := newAIAServer()
+	defer server.Close()
+
+	root := newTestCA(t, "Test Root", nil, "")
+	intermediate := newTestCA(t, "Test Intermediate", root, "")
+	server.files["/int.der"] = intermediate.Cert.Raw
+
+	leaf := intermediate.issue(t, "www.example.com", func(c *x509.Certificate) {
+		c.IssuingCertificateURL = []string{server.URL + "/int.der"}
+	})
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root.Cert)
+	cache := t.TempDir()
+
+	for i := 0; i < 3; i++ {
+		fetcher := &AIAFetcher{Client: server.Client(), CacheDir: cache, Roots: roots}
+		chain, errs := fetcher.CompleteChain([]*x509.Certificate{leaf})
+		if len(chain) != 2 || len(errs) != 0 {
+			t.Fatalf("run %v: chain %v, errors %v", i, names(chain), errs)
+		}
+	}
+
+	if n := server.count("/int.der"); n != 1 {
+		t.Errorf("intermediate fetched %v times, want once (then cached)", n)
+	}
+}
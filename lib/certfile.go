@@ -0,0 +1,195 @@
+//
+// Copyright (C) 2017 Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package lib
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/pkcs12"
+)
+
+// ErrPasswordRequired is returned when a PKCS#12 keystore or an
+// encrypted private key can't be opened with the password given (or
+// the empty password).
+var ErrPasswordRequired = errors.New("password required or incorrect")
+
+var oidSignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+
+// ParseCertificates returns every certificate in data, which may be
+// one or more PEM blocks (certificates or PKCS#7), DER encoded
+// certificates, a DER PKCS#7 bundle (.p7b) or a PKCS#12 keystore
+// (.p12/.pfx) opened with password.
+func ParseCertificates(data []byte, password string) ([]*x509.Certificate, error) {
+
+	if bytes.Contains(data, []byte("-----BEGIN")) {
+		return parsePEMCertificates(data)
+	}
+
+	if certs, err := x509.ParseCertificates(data); err == nil && len(certs) > 0 {
+		return certs, nil
+	}
+
+	if certs, err := parsePKCS7Certificates(data); err == nil {
+		return certs, nil
+	}
+
+	return parsePKCS12Certificates(data, password)
+}
+
+func parsePEMCertificates(data []byte) ([]*x509.Certificate, error) {
+	certs := make([]*x509.Certificate, 0)
+
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		switch block.Type {
+
+		case "CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			certs = append(certs, cert)
+
+		case "PKCS7":
+			more, err := parsePKCS7Certificates(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			certs = append(certs, more...)
+		}
+	}
+
+	if len(certs) == 0 {
+		return nil, errors.New("no certificates found in PEM data")
+	}
+
+	return certs, nil
+}
+
+// parsePKCS7Certificates pulls the certificates out of a PKCS#7
+// SignedData structure (RFC 2315), ignoring everything else.
+func parsePKCS7Certificates(data []byte) ([]*x509.Certificate, error) {
+
+	var info struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+	}
+
+	if _, err := asn1.Unmarshal(data, &info); err != nil {
+		return nil, err
+	}
+	if !info.ContentType.Equal(oidSignedData) {
+		return nil, fmt.Errorf("PKCS#7 content type %v is not signed data", info.ContentType)
+	}
+
+	var signed asn1.RawValue
+	if _, err := asn1.Unmarshal(info.Content.Bytes, &signed); err != nil {
+		return nil, err
+	}
+
+	// Certificates are the optional [0] IMPLICIT element.
+	rest := signed.Bytes
+	for len(rest) > 0 {
+		var v asn1.RawValue
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &v); err != nil {
+			return nil, err
+		}
+		if v.Class == asn1.ClassContextSpecific && v.Tag == 0 {
+			return x509.ParseCertificates(v.Bytes)
+		}
+	}
+
+	return nil, errors.New("no certificates found in PKCS#7 data")
+}
+
+func parsePKCS12Certificates(data []byte, password string) ([]*x509.Certificate, error) {
+	certs, _, err := ParsePKCS12(data, password)
+	if err == errNotPKCS12 {
+		return nil, errors.New("unrecognized certificate format (tried PEM, DER, PKCS#7 and PKCS#12)")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(certs) == 0 {
+		return nil, errors.New("no certificates found in PKCS#12 data")
+	}
+
+	return certs, nil
+}
+
+var errNotPKCS12 = errors.New("not a PKCS#12 keystore")
+
+// ParsePKCS12 returns the certificates and private keys in a PKCS#12
+// keystore (.p12/.pfx) opened with password.
+func ParsePKCS12(data []byte, password string) ([]*x509.Certificate, []crypto.Signer, error) {
+	blocks, err := pkcs12.ToPEM(data, password)
+	if err == pkcs12.ErrIncorrectPassword {
+		return nil, nil, ErrPasswordRequired
+	}
+	if _, ok := err.(pkcs12.NotImplementedError); ok {
+		return nil, nil, err
+	}
+	if err != nil {
+		return nil, nil, errNotPKCS12
+	}
+
+	certs := make([]*x509.Certificate, 0)
+	keys := make([]crypto.Signer, 0)
+	for _, block := range blocks {
+		switch block.Type {
+		case "CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, nil, err
+			}
+			certs = append(certs, cert)
+		case "PRIVATE KEY":
+			key, err := parsePKCS12Key(block.Bytes)
+			if err != nil {
+				return nil, nil, err
+			}
+			keys = append(keys, key)
+		}
+	}
+
+	return certs, keys, nil
+}
+
+// parsePKCS12Key parses a key from pkcs12.ToPEM, which despite the
+// block type is PKCS#1 (RSA) or SEC 1 (EC) rather than PKCS#8.
+func parsePKCS12Key(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	return parsePKCS8(der)
+}
@@ -0,0 +1,466 @@
+//
+// Copyright (C) 2017 Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package lib
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var keyUsageNames = []string{"DigitalSignature", "ContentCommitment", "KeyEncipherment",
+	"DataEncipherment", "KeyAgreement", "CertSign", "CRLSign", "EncipherOnly",
+	"DecipherOnly"}
+
+// Attribute is one attribute of a distinguished name.
+type Attribute struct {
+	Type  string `json:"type"` // short name, e.g. CN, or the OID
+	OID   string `json:"oid"`
+	Value string `json:"value"`
+}
+
+// Name is a certificate subject or issuer.
+type Name struct {
+	CommonName         string      `json:"commonName,omitempty"`
+	SerialNumber       string      `json:"serialNumber,omitempty"`
+	Country            []string    `json:"country,omitempty"`
+	Organization       []string    `json:"organization,omitempty"`
+	OrganizationalUnit []string    `json:"organizationalUnit,omitempty"`
+	Locality           []string    `json:"locality,omitempty"`
+	Province           []string    `json:"province,omitempty"`
+	StreetAddress      []string    `json:"streetAddress,omitempty"`
+	PostalCode         []string    `json:"postalCode,omitempty"`
+	Names              []Attribute `json:"names"`
+	String             string      `json:"string"`
+}
+
+// Extension is a certificate extension. Well known extensions are
+// decoded, the rest (or any that fail to decode) keep their raw value.
+type Extension struct {
+	ID       string         `json:"id"`
+	Name     string         `json:"name,omitempty"`
+	Critical bool           `json:"critical"`
+	Decoded  ExtensionValue `json:"decoded,omitempty"`
+	Value    []byte         `json:"value,omitempty"`
+	Error    string         `json:"error,omitempty"`
+}
+
+// PublicKey describes a cert's subject public key.
+type PublicKey struct {
+	Algorithm string `json:"algorithm"`
+	Size      int    `json:"size"`               // bits
+	Curve     string `json:"curve,omitempty"`    // ECDSA only
+	Exponent  int    `json:"exponent,omitempty"` // RSA only
+	PinSHA256 string `json:"pinSha256"`          // base64 SHA-256 of the SPKI, as for HPKP
+}
+
+// Fingerprints are hashes of the whole DER encoded cert.
+type Fingerprints struct {
+	SHA1   string `json:"sha1"`
+	SHA256 string `json:"sha256"`
+}
+
+// Verification is the result of verifying a cert's chain of trust.
+type Verification struct {
+	Verified bool       `json:"verified"`
+	Error    string     `json:"error,omitempty"`
+	Chains   [][]string `json:"chains,omitempty"`
+}
+
+// Certificate describes an X.509 certificate with typed, readable
+// fields, suitable for JSON, from which the flat text properties are
+// derived.
+type Certificate struct {
+	Version                     int           `json:"version"`
+	SerialNumber                string        `json:"serialNumber"`
+	Issuer                      Name          `json:"issuer"`
+	Subject                     Name          `json:"subject"`
+	NotBefore                   time.Time     `json:"notBefore"`
+	NotAfter                    time.Time     `json:"notAfter"`
+	KeyUsage                    []string      `json:"keyUsage"`
+	Extensions                  []Extension   `json:"extensions"`
+	UnhandledCriticalExtensions []string      `json:"unhandledCriticalExtensions"`
+	ExtKeyUsage                 []string      `json:"extKeyUsage"`
+	UnknownExtKeyUsage          []string      `json:"unknownExtKeyUsage"`
+	Signature                   []byte        `json:"signature"`
+	SignatureAlgorithm          string        `json:"signatureAlgorithm"`
+	PublicKey                   PublicKey     `json:"publicKey"`
+	Fingerprints                Fingerprints  `json:"fingerprints"`
+	BasicConstraintsValid       bool          `json:"basicConstraintsValid"`
+	IsCA                        bool          `json:"isCA"`
+	MaxPathLen                  int           `json:"maxPathLen"`
+	MaxPathLenZero              bool          `json:"maxPathLenZero"`
+	SubjectKeyID                []byte        `json:"subjectKeyId"`
+	AuthorityKeyID              []byte        `json:"authorityKeyId"`
+	OCSPServer                  []string      `json:"ocspServer"`
+	IssuingCertificateURL       []string      `json:"issuingCertificateUrl"`
+	DNSNames                    []string      `json:"dnsNames"`
+	EmailAddresses              []string      `json:"emailAddresses"`
+	IPAddresses                 []string      `json:"ipAddresses"`
+	PermittedDNSDomainsCritical bool          `json:"permittedDnsDomainsCritical"`
+	PermittedDNSDomains         []string      `json:"permittedDnsDomains"`
+	ExcludedDNSDomains          []string      `json:"excludedDnsDomains"`
+	CRLDistributionPoints       []string      `json:"crlDistributionPoints"`
+	PolicyIdentifiers           []string      `json:"policyIdentifiers"`
+	Verification                *Verification `json:"verification,omitempty"`
+	Raw                         []byte        `json:"raw"`
+
+	cert *x509.Certificate
+}
+
+func strs(xs []string) []string {
+	if xs == nil {
+		return make([]string, 0)
+	}
+	return xs
+}
+
+func stringers(n int, at func(i int) fmt.Stringer) []string {
+	out := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		out = append(out, at(i).String())
+	}
+	return out
+}
+
+func newName(pn pkix.Name) Name {
+	names := make([]Attribute, 0)
+	for _, atv := range pn.Names {
+		names = append(names, Attribute{
+			Type:  attributeName(atv.Type),
+			OID:   atv.Type.String(),
+			Value: strings.TrimSpace(fmt.Sprintf("%v", atv.Value)),
+		})
+	}
+
+	return Name{
+		CommonName:         pn.CommonName,
+		SerialNumber:       pn.SerialNumber,
+		Country:            pn.Country,
+		Organization:       pn.Organization,
+		OrganizationalUnit: pn.OrganizationalUnit,
+		Locality:           pn.Locality,
+		Province:           pn.Province,
+		StreetAddress:      pn.StreetAddress,
+		PostalCode:         pn.PostalCode,
+		Names:              names,
+		String:             pn.String(),
+	}
+}
+
+func keyUsages(usage x509.KeyUsage) []string {
+	names := make([]string, 0)
+	for i, name := range keyUsageNames {
+		if usage&(1<<uint(i)) != 0 {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func extKeyUsageName(usage x509.ExtKeyUsage) string {
+	if int(usage) < len(extKeyUsageNames) {
+		return extKeyUsageNames[usage]
+	}
+	return fmt.Sprintf("%v", int(usage))
+}
+
+func newPublicKey(algorithm x509.PublicKeyAlgorithm, key interface{}, spki []byte) PublicKey {
+	pin := sha256.Sum256(spki)
+	pk := PublicKey{
+		Algorithm: algorithm.String(),
+		PinSHA256: base64.StdEncoding.EncodeToString(pin[:]),
+	}
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		pk.Size = key.N.BitLen()
+		pk.Exponent = key.E
+	case *ecdsa.PublicKey:
+		pk.Size = key.Curve.Params().BitSize
+		pk.Curve = key.Curve.Params().Name
+	case ed25519.PublicKey:
+		pk.Size = 256
+	}
+
+	return pk
+}
+
+func newFingerprints(cert *x509.Certificate) Fingerprints {
+	s1 := sha1.Sum(cert.Raw)
+	s256 := sha256.Sum256(cert.Raw)
+	return Fingerprints{SHA1: hexID(s1[:]), SHA256: hexID(s256[:])}
+}
+
+// newExtensions decodes exts, with the help of cert if there is one.
+func newExtensions(exts []pkix.Extension, cert *x509.Certificate) []Extension {
+	extensions := make([]Extension, 0, len(exts))
+	for _, ex := range exts {
+		e := Extension{
+			ID:       ex.Id.String(),
+			Name:     extensionNames[ex.Id.String()].name,
+			Critical: ex.Critical,
+		}
+		decoded, err := decodeExtension(ex, cert)
+		switch {
+		case err != nil:
+			e.Error = err.Error()
+			e.Value = ex.Value
+		case decoded == nil:
+			e.Value = ex.Value
+		default:
+			e.Decoded = decoded
+		}
+		extensions = append(extensions, e)
+	}
+	return extensions
+}
+
+// NewCertificate describes cert.
+func NewCertificate(cert *x509.Certificate) *Certificate {
+
+	c := &Certificate{
+		Version:                     cert.Version,
+		SerialNumber:                cert.SerialNumber.String(),
+		Issuer:                      newName(cert.Issuer),
+		Subject:                     newName(cert.Subject),
+		NotBefore:                   cert.NotBefore,
+		NotAfter:                    cert.NotAfter,
+		KeyUsage:                    keyUsages(cert.KeyUsage),
+		ExtKeyUsage:                 make([]string, 0),
+		Signature:                   cert.Signature,
+		SignatureAlgorithm:          cert.SignatureAlgorithm.String(),
+		PublicKey:                   newPublicKey(cert.PublicKeyAlgorithm, cert.PublicKey, cert.RawSubjectPublicKeyInfo),
+		Fingerprints:                newFingerprints(cert),
+		BasicConstraintsValid:       cert.BasicConstraintsValid,
+		IsCA:                        cert.IsCA,
+		MaxPathLen:                  cert.MaxPathLen,
+		MaxPathLenZero:              cert.MaxPathLenZero,
+		SubjectKeyID:                cert.SubjectKeyId,
+		AuthorityKeyID:              cert.AuthorityKeyId,
+		OCSPServer:                  strs(cert.OCSPServer),
+		IssuingCertificateURL:       strs(cert.IssuingCertificateURL),
+		DNSNames:                    strs(cert.DNSNames),
+		EmailAddresses:              strs(cert.EmailAddresses),
+		PermittedDNSDomainsCritical: cert.PermittedDNSDomainsCritical,
+		PermittedDNSDomains:         strs(cert.PermittedDNSDomains),
+		ExcludedDNSDomains:          strs(cert.ExcludedDNSDomains),
+		CRLDistributionPoints:       strs(cert.CRLDistributionPoints),
+		Raw:                         cert.Raw,
+		cert:                        cert,
+	}
+
+	c.Extensions = newExtensions(cert.Extensions, cert)
+
+	for _, usage := range cert.ExtKeyUsage {
+		c.ExtKeyUsage = append(c.ExtKeyUsage, extKeyUsageName(usage))
+	}
+
+	c.UnhandledCriticalExtensions = stringers(len(cert.UnhandledCriticalExtensions),
+		func(i int) fmt.Stringer { return cert.UnhandledCriticalExtensions[i] })
+	c.UnknownExtKeyUsage = stringers(len(cert.UnknownExtKeyUsage),
+		func(i int) fmt.Stringer { return cert.UnknownExtKeyUsage[i] })
+	c.IPAddresses = stringers(len(cert.IPAddresses),
+		func(i int) fmt.Stringer { return cert.IPAddresses[i] })
+	c.PolicyIdentifiers = stringers(len(cert.PolicyIdentifiers),
+		func(i int) fmt.Stringer { return cert.PolicyIdentifiers[i] })
+
+	return c
+}
+
+// NewChain describes each of certs, verifying the first (the leaf)
+// with opts.
+func NewChain(certs []*x509.Certificate, opts x509.VerifyOptions) []*Certificate {
+	chain := make([]*Certificate, 0, len(certs))
+	for _, cert := range certs {
+		chain = append(chain, NewCertificate(cert))
+	}
+	if len(chain) > 0 {
+		chain[0].Verify(certs[1:], opts)
+	}
+	return chain
+}
+
+// Verify verifies the cert with opts, recording the result. The
+// intermediates are used unless opts has its own.
+func (c *Certificate) Verify(intermediates []*x509.Certificate, opts x509.VerifyOptions) *Verification {
+
+	if opts.Intermediates == nil {
+		opts.Intermediates = x509.NewCertPool()
+		for _, i := range intermediates {
+			opts.Intermediates.AddCert(i)
+		}
+	}
+
+	c.Verification = &Verification{}
+
+	chains, err := c.cert.Verify(opts)
+	if err != nil {
+		c.Verification.Error = err.Error()
+		return c.Verification
+	}
+
+	c.Verification.Verified = true
+	for _, chain := range chains {
+		names := make([]string, 0)
+		for _, link := range chain {
+			names = append(names, CertName(link))
+		}
+		c.Verification.Chains = append(c.Verification.Chains, names)
+	}
+
+	return c.Verification
+}
+
+// propertySetter returns a function setting prefix+key to a value
+// formatted for the flat property view.
+func propertySetter(properties *FIFOMap, prefix string) func(string, interface{}) {
+	spf := func(x interface{}) string {
+		switch t := x.(type) {
+		case string:
+			return strings.TrimSpace(t)
+		case []string:
+			return strings.Join(t, ", ")
+		case time.Time:
+			return t.Format(time.RFC3339)
+		case []byte:
+			return base64.StdEncoding.EncodeToString(t)
+		default:
+			return fmt.Sprintf("%v", t)
+		}
+	}
+
+	return func(prop string, value interface{}) {
+		properties.Set(prefix+prop, spf(value))
+	}
+}
+
+func nameProperties(pp func(string, interface{}), prefix string, pn Name) {
+	pp(prefix+".country", pn.Country)
+	pp(prefix+".organization", pn.Organization)
+	pp(prefix+".organizational.unit", pn.OrganizationalUnit)
+	pp(prefix+".street.address", pn.StreetAddress)
+	pp(prefix+".postal.code", pn.PostalCode)
+	pp(prefix+".serial.number", pn.SerialNumber)
+	pp(prefix+".common.name", pn.CommonName)
+	for i, a := range pn.Names {
+		pp(fmt.Sprintf("%v.names.%v", prefix, i), a.Type+", "+a.Value)
+	}
+}
+
+func extensionProperties(pp func(string, interface{}), extensions []Extension) {
+	if len(extensions) == 0 {
+		pp("extensions", "")
+	}
+	for _, ex := range extensions {
+		key := extensionNames[ex.ID].key
+		if key == "" {
+			key = ex.ID
+		}
+		prop := "extensions." + key + "."
+		pp(prop+"critical", ex.Critical)
+		if ex.Decoded != nil {
+			for _, f := range ex.Decoded.fields() {
+				pp(prop+f.key, f.value)
+			}
+			continue
+		}
+		pp(prop+"value", ex.Value)
+		if ex.Error != "" {
+			pp(prop+"error", ex.Error)
+		}
+	}
+}
+
+func publicKeyProperties(pp func(string, interface{}), pk PublicKey) {
+	pp("public.key.algorithm", pk.Algorithm)
+	pp("public.key.size", pk.Size)
+	if pk.Curve != "" {
+		pp("public.key.curve", pk.Curve)
+	}
+	if pk.Exponent != 0 {
+		pp("public.key.exponent", pk.Exponent)
+	}
+	pp("public.key.pin.sha256", pk.PinSHA256)
+}
+
+// Properties returns the flat "cert." keys to values view of the
+// certificate.
+func (c *Certificate) Properties() *FIFOMap {
+
+	properties := NewFIFOMap()
+	pp := propertySetter(properties, "cert.")
+
+	pp("version", c.Version)
+	pp("serial.number", c.SerialNumber)
+	nameProperties(pp, "issuer", c.Issuer)
+	nameProperties(pp, "subject", c.Subject)
+	pp("not.valid.before", c.NotBefore)
+	pp("not.valid.after", c.NotAfter)
+	pp("keyusage", c.KeyUsage)
+
+	extensionProperties(pp, c.Extensions)
+
+	pp("unhandled.critical.extensions", c.UnhandledCriticalExtensions)
+	pp("extended.key.usages", c.ExtKeyUsage)
+	pp("extended.key.usages.unknown", c.UnknownExtKeyUsage)
+
+	pp("signature", c.Signature)
+	pp("signature.algorithm", c.SignatureAlgorithm)
+	publicKeyProperties(pp, c.PublicKey)
+	pp("fingerprint.sha1", c.Fingerprints.SHA1)
+	pp("fingerprint.sha256", c.Fingerprints.SHA256)
+	pp("basic.contraints.valid", c.BasicConstraintsValid)
+	pp("is.ca", c.IsCA)
+	pp("max.path.len", c.MaxPathLen)
+	pp("max.path.len.zero", c.MaxPathLenZero)
+	pp("subject.key.id", c.SubjectKeyID)
+	pp("authority.key.id", c.AuthorityKeyID)
+	pp("ocsp.server", c.OCSPServer)
+	pp("issuing.certificate.url", c.IssuingCertificateURL)
+	pp("dns.names", c.DNSNames)
+	pp("email.addresses", c.EmailAddresses)
+	pp("ip.addresses", c.IPAddresses)
+	pp("dns.domains.permitted.critical", c.PermittedDNSDomainsCritical)
+	pp("dns.domains.permitted", c.PermittedDNSDomains)
+	pp("dns.domains.excluded", c.ExcludedDNSDomains)
+	pp("crl.distribution.points", c.CRLDistributionPoints)
+	pp("policy.identifiers", c.PolicyIdentifiers)
+
+	if v := c.Verification; v != nil {
+		if !v.Verified {
+			pp("verified", fmt.Sprintf("%v, %v", false, v.Error))
+			return properties
+		}
+
+		pp("verified", "true")
+		pp("verified.chains", len(v.Chains))
+		for i, chain := range v.Chains {
+			pp(fmt.Sprintf("verified.chain.%v", i), strings.Join(chain, " > "))
+		}
+	}
+
+	return properties
+}
@@ -0,0 +1,216 @@
+//
+// Copyright (C) 2017 Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package lib
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// colonHex formats data as upper case hex bytes separated by colons.
+func colonHex(data []byte) string {
+	parts := make([]string, 0, len(data))
+	for _, b := range data {
+		parts = append(parts, fmt.Sprintf("%02X", b))
+	}
+	return strings.Join(parts, ":")
+}
+
+func TestCertificateProperties(t *testing.T) {
+	ca := newTestCA(t, "Test Root", nil, "")
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey := readTestKey(t, "rsa-key.pem").(*rsa.PrivateKey)
+
+	tests := []struct {
+		name string
+		key  crypto.Signer
+		want map[string]string // and no others with the prefix
+	}{
+		{"ECDSA", newTestKey(t), map[string]string{
+			"cert.public.key.algorithm": "ECDSA",
+			"cert.public.key.size":      "256",
+			"cert.public.key.curve":     "P-256",
+		}},
+		{"RSA", rsaKey, map[string]string{
+			"cert.public.key.algorithm": "RSA",
+			"cert.public.key.size":      "2048",
+			"cert.public.key.exponent":  fmt.Sprintf("%v", rsaKey.E),
+		}},
+		{"Ed25519", edKey, map[string]string{
+			"cert.public.key.algorithm": "Ed25519",
+			"cert.public.key.size":      "256",
+		}},
+	}
+
+	for _, test := range tests {
+		template := &x509.Certificate{
+			Subject:  pkix.Name{CommonName: "www.example.com"},
+			DNSNames: []string{"www.example.com"},
+		}
+		leaf := newTestCert(t, template, test.key, ca)
+		properties := NewCertificate(leaf).Properties()
+
+		pin := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+		s1 := sha1.Sum(leaf.Raw)
+		s256 := sha256.Sum256(leaf.Raw)
+		want := map[string]string{
+			"cert.subject.common.name":   "www.example.com",
+			"cert.issuer.common.name":    "Test Root",
+			"cert.public.key.pin.sha256": base64.StdEncoding.EncodeToString(pin[:]),
+			"cert.fingerprint.sha1":      colonHex(s1[:]),
+			"cert.fingerprint.sha256":    colonHex(s256[:]),
+		}
+		for k, v := range test.want {
+			want[k] = v
+		}
+
+		for k, v := range want {
+			if got := properties.Get(k); got != v {
+				t.Errorf("%v: %v = %q, want %q", test.name, k, got, v)
+			}
+		}
+		for _, k := range properties.Keys() {
+			if _, ok := want[k]; !ok && strings.HasPrefix(k, "cert.public.key.") {
+				t.Errorf("%v: unexpected %v = %q", test.name, k, properties.Get(k))
+			}
+			if k == "cert.extensions.extra" {
+				t.Errorf("%v: %v is still emitted", test.name, k)
+			}
+		}
+	}
+}
+
+// jsonKeys returns the sorted keys of the JSON object v.
+func jsonKeys(v interface{}) []string {
+	keys := make([]string, 0)
+	if m, ok := v.(map[string]interface{}); ok {
+		for k := range m {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestCertificateJSON(t *testing.T) {
+	certs, err := ParseCertificates(readTestdata(t, "cert.pem"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(NewCertificate(certs[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]interface{}
+	if err = json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	// No verification unless the cert is part of a chain.
+	want := []string{"authorityKeyId", "basicConstraintsValid", "crlDistributionPoints",
+		"dnsNames", "emailAddresses", "excludedDnsDomains", "extKeyUsage", "extensions",
+		"fingerprints", "ipAddresses", "isCA", "issuer", "issuingCertificateUrl", "keyUsage",
+		"maxPathLen", "maxPathLenZero", "notAfter", "notBefore", "ocspServer",
+		"permittedDnsDomains", "permittedDnsDomainsCritical", "policyIdentifiers", "publicKey",
+		"raw", "serialNumber", "signature", "signatureAlgorithm", "subject", "subjectKeyId",
+		"unhandledCriticalExtensions", "unknownExtKeyUsage", "version"}
+	if got := jsonKeys(doc); !reflect.DeepEqual(got, want) {
+		t.Errorf("keys: got %v, want %v", got, want)
+	}
+
+	objects := []struct {
+		key  string
+		want []string
+	}{
+		{"issuer", []string{"commonName", "names", "string"}},
+		{"subject", []string{"commonName", "names", "string"}},
+		{"publicKey", []string{"algorithm", "curve", "pinSha256", "size"}},
+		{"fingerprints", []string{"sha1", "sha256"}},
+	}
+	for _, o := range objects {
+		if got := jsonKeys(doc[o.key]); !reflect.DeepEqual(got, o.want) {
+			t.Errorf("%v: got %v, want %v", o.key, got, o.want)
+		}
+	}
+
+	names, _ := doc["subject"].(map[string]interface{})["names"].([]interface{})
+	if len(names) != 1 || !reflect.DeepEqual(jsonKeys(names[0]), []string{"oid", "type", "value"}) {
+		t.Errorf("subject names: %v", names)
+	}
+
+	extensions, _ := doc["extensions"].([]interface{})
+	if len(extensions) != 3 {
+		t.Fatalf("extensions: got %v, want 3", len(extensions))
+	}
+	for _, ex := range extensions {
+		if got := jsonKeys(ex); !reflect.DeepEqual(got, []string{"critical", "decoded", "id", "name"}) {
+			t.Errorf("extension keys: got %v", got)
+		}
+	}
+
+	// Empty lists are [], not null, so consumers needn't check.
+	for _, key := range []string{"keyUsage", "extKeyUsage", "dnsNames", "ipAddresses", "policyIdentifiers"} {
+		if list, ok := doc[key].([]interface{}); !ok || len(list) != 0 {
+			t.Errorf("%v: got %#v, want []", key, doc[key])
+		}
+	}
+
+	var back struct {
+		Raw       []byte `json:"raw"`
+		NotBefore string `json:"notBefore"`
+	}
+	if err = json.Unmarshal(data, &back); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(back.Raw, certs[0].Raw) {
+		t.Errorf("raw does not round trip")
+	}
+	if back.NotBefore != "2026-10-18T11:52:17Z" {
+		t.Errorf("notBefore: got %v", back.NotBefore)
+	}
+
+	chain := NewChain(certs, x509.VerifyOptions{})
+	data, err = json.Marshal(chain[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc = nil
+	if err = json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if got := jsonKeys(doc["verification"]); !reflect.DeepEqual(got, []string{"error", "verified"}) {
+		t.Errorf("verification: got %v, want [error verified]", got)
+	}
+}
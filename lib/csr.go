@@ -0,0 +1,125 @@
+//
+// Copyright (C) 2017 Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package lib
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+// CertificateRequest describes a certificate signing request, with
+// the same field names as a Certificate where they overlap.
+type CertificateRequest struct {
+	Version            int         `json:"version"`
+	Subject            Name        `json:"subject"`
+	PublicKey          PublicKey   `json:"publicKey"`
+	SignatureAlgorithm string      `json:"signatureAlgorithm"`
+	SignatureValid     bool        `json:"signatureValid"`
+	SignatureError     string      `json:"signatureError,omitempty"`
+	Extensions         []Extension `json:"extensions"`
+	DNSNames           []string    `json:"dnsNames"`
+	EmailAddresses     []string    `json:"emailAddresses"`
+	IPAddresses        []string    `json:"ipAddresses"`
+	URIs               []string    `json:"uris"`
+	Raw                []byte      `json:"raw"`
+}
+
+// NewCertificateRequest describes csr, checking its signature.
+func NewCertificateRequest(csr *x509.CertificateRequest) *CertificateRequest {
+	r := &CertificateRequest{
+		Version:            csr.Version,
+		Subject:            newName(csr.Subject),
+		PublicKey:          newPublicKey(csr.PublicKeyAlgorithm, csr.PublicKey, csr.RawSubjectPublicKeyInfo),
+		SignatureAlgorithm: csr.SignatureAlgorithm.String(),
+		Extensions:         newExtensions(csr.Extensions, nil),
+		DNSNames:           strs(csr.DNSNames),
+		EmailAddresses:     strs(csr.EmailAddresses),
+		Raw:                csr.Raw,
+	}
+
+	if err := csr.CheckSignature(); err != nil {
+		r.SignatureError = err.Error()
+	} else {
+		r.SignatureValid = true
+	}
+
+	r.IPAddresses = stringers(len(csr.IPAddresses),
+		func(i int) fmt.Stringer { return csr.IPAddresses[i] })
+	r.URIs = stringers(len(csr.URIs),
+		func(i int) fmt.Stringer { return csr.URIs[i] })
+
+	return r
+}
+
+// Properties returns the flat "csr." keys to values view of the
+// request, named as for a certificate's "cert." keys.
+func (r *CertificateRequest) Properties() *FIFOMap {
+	properties := NewFIFOMap()
+	pp := propertySetter(properties, "csr.")
+
+	pp("version", r.Version)
+	nameProperties(pp, "subject", r.Subject)
+	extensionProperties(pp, r.Extensions)
+	pp("signature.algorithm", r.SignatureAlgorithm)
+	if r.SignatureValid {
+		pp("signature.valid", true)
+	} else {
+		pp("signature.valid", fmt.Sprintf("%v, %v", false, r.SignatureError))
+	}
+	publicKeyProperties(pp, r.PublicKey)
+	pp("dns.names", r.DNSNames)
+	pp("email.addresses", r.EmailAddresses)
+	pp("ip.addresses", r.IPAddresses)
+	pp("uris", r.URIs)
+
+	return properties
+}
+
+// ParseCertificateRequests returns the CSRs in data, which may be any
+// number of PEM blocks or a single DER encoded request.
+func ParseCertificateRequests(data []byte) ([]*x509.CertificateRequest, error) {
+	csrs := make([]*x509.CertificateRequest, 0)
+
+	rest := data
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE REQUEST" && block.Type != "NEW CERTIFICATE REQUEST" {
+			continue
+		}
+		csr, err := x509.ParseCertificateRequest(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		csrs = append(csrs, csr)
+	}
+
+	if len(csrs) > 0 {
+		return csrs, nil
+	}
+
+	if csr, err := x509.ParseCertificateRequest(data); err == nil {
+		return append(csrs, csr), nil
+	}
+
+	return nil, errors.New("no certificate requests found")
+}
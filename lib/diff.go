@@ -0,0 +1,67 @@
+//
+// Copyright (C) 2017 Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package lib
+
+// Kinds of property change.
+const (
+	PropertyAdded   = "added"
+	PropertyRemoved = "removed"
+	PropertyChanged = "changed"
+)
+
+// PropertyChange is a difference in one key between two FIFOMaps.
+type PropertyChange struct {
+	Key  string `json:"key"`
+	Kind string `json:"kind"`
+	Old  string `json:"old,omitempty"`
+	New  string `json:"new,omitempty"`
+}
+
+// DiffProperties returns the keys removed from, changed in, or added
+// to a to get b, in a's key order followed by b's new keys. Keys for
+// which ignore returns true are skipped.
+func DiffProperties(a, b *FIFOMap, ignore func(key string) bool) []PropertyChange {
+	changes := make([]PropertyChange, 0)
+
+	skip := func(key string) bool {
+		return ignore != nil && ignore(key)
+	}
+
+	for _, k := range a.Keys() {
+		if skip(k) {
+			continue
+		}
+		if _, ok := b.data[k]; !ok {
+			changes = append(changes, PropertyChange{Key: k, Kind: PropertyRemoved, Old: a.Get(k)})
+			continue
+		}
+		if a.Get(k) != b.Get(k) {
+			changes = append(changes, PropertyChange{Key: k, Kind: PropertyChanged, Old: a.Get(k), New: b.Get(k)})
+		}
+	}
+
+	for _, k := range b.Keys() {
+		if skip(k) {
+			continue
+		}
+		if _, ok := a.data[k]; !ok {
+			changes = append(changes, PropertyChange{Key: k, Kind: PropertyAdded, New: b.Get(k)})
+		}
+	}
+
+	return changes
+}
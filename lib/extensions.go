@@ -0,0 +1,644 @@
+//
+// Copyright (C) 2017 Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package lib
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// Short names for distinguished name attributes.
+var attributeNames = map[string]string{
+	"2.5.4.3":                    "CN",
+	"2.5.4.4":                    "SN",
+	"2.5.4.5":                    "SERIALNUMBER",
+	"2.5.4.6":                    "C",
+	"2.5.4.7":                    "L",
+	"2.5.4.8":                    "ST",
+	"2.5.4.9":                    "STREET",
+	"2.5.4.10":                   "O",
+	"2.5.4.11":                   "OU",
+	"2.5.4.12":                   "title",
+	"2.5.4.15":                   "businessCategory",
+	"2.5.4.17":                   "postalCode",
+	"2.5.4.42":                   "GN",
+	"2.5.4.97":                   "organizationIdentifier",
+	"0.9.2342.19200300.100.1.1":  "UID",
+	"0.9.2342.19200300.100.1.25": "DC",
+	"1.2.840.113549.1.9.1":       "emailAddress",
+	"1.3.6.1.4.1.311.60.2.1.1":   "jurisdictionL",
+	"1.3.6.1.4.1.311.60.2.1.2":   "jurisdictionST",
+	"1.3.6.1.4.1.311.60.2.1.3":   "jurisdictionC",
+}
+
+// Names for well known extensions, and the key they're given in the
+// flat property view.
+var extensionNames = map[string]struct{ name, key string }{
+	"2.5.29.14":               {"subjectKeyIdentifier", "subject.key.identifier"},
+	"2.5.29.15":               {"keyUsage", "key.usage"},
+	"2.5.29.17":               {"subjectAltName", "subject.alt.name"},
+	"2.5.29.18":               {"issuerAltName", "issuer.alt.name"},
+	"2.5.29.19":               {"basicConstraints", "basic.constraints"},
+	"2.5.29.30":               {"nameConstraints", "name.constraints"},
+	"2.5.29.31":               {"cRLDistributionPoints", "crl.distribution.points"},
+	"2.5.29.32":               {"certificatePolicies", "certificate.policies"},
+	"2.5.29.35":               {"authorityKeyIdentifier", "authority.key.identifier"},
+	"2.5.29.37":               {"extKeyUsage", "ext.key.usage"},
+	"1.3.6.1.5.5.7.1.1":       {"authorityInfoAccess", "authority.info.access"},
+	"1.3.6.1.5.5.7.1.24":      {"tlsFeature", "tls.feature"},
+	"1.3.6.1.4.1.11129.2.4.2": {"signedCertificateTimestampList", "sct.list"},
+	"1.3.6.1.4.1.11129.2.4.3": {"precertificatePoison", "precertificate.poison"},
+}
+
+// The extKeyUsageNames index for each extended key usage OID.
+var extKeyUsageOIDs = map[string]int{
+	"2.5.29.37.0":            0,
+	"1.3.6.1.5.5.7.3.1":      1,
+	"1.3.6.1.5.5.7.3.2":      2,
+	"1.3.6.1.5.5.7.3.3":      3,
+	"1.3.6.1.5.5.7.3.4":      4,
+	"1.3.6.1.5.5.7.3.5":      5,
+	"1.3.6.1.5.5.7.3.6":      6,
+	"1.3.6.1.5.5.7.3.7":      7,
+	"1.3.6.1.5.5.7.3.8":      8,
+	"1.3.6.1.5.5.7.3.9":      9,
+	"1.3.6.1.4.1.311.10.3.3": 10,
+	"2.16.840.1.113730.4.1":  11,
+}
+
+// Names for well known certificate policies.
+var policyNames = map[string]string{
+	"2.5.29.32.0":    "anyPolicy",
+	"2.23.140.1.1":   "extendedValidation",
+	"2.23.140.1.2.1": "domainValidated",
+	"2.23.140.1.2.2": "organizationValidated",
+	"2.23.140.1.2.3": "individualValidated",
+}
+
+var tlsFeatureNames = map[int]string{
+	5:  "status_request (must-staple)",
+	17: "status_request_v2",
+}
+
+var sctHashNames = []string{"none", "md5", "sha1", "sha224", "sha256", "sha384", "sha512"}
+var sctSignatureNames = []string{"anonymous", "rsa", "dsa", "ecdsa"}
+
+var (
+	oidQualifierCPS        = "1.3.6.1.5.5.7.2.1"
+	oidQualifierUserNotice = "1.3.6.1.5.5.7.2.2"
+)
+
+// attributeName returns the short name for a distinguished name
+// attribute type, or the OID if it's not a well known one.
+func attributeName(oid asn1.ObjectIdentifier) string {
+	if name, ok := attributeNames[oid.String()]; ok {
+		return name
+	}
+	return oid.String()
+}
+
+// field is one decoded value of an extension, for the flat property
+// view.
+type field struct {
+	key   string
+	value interface{}
+}
+
+// ExtensionValue is a decoded extension.
+type ExtensionValue interface {
+	fields() []field
+}
+
+// BasicConstraints is the decoded basicConstraints extension.
+type BasicConstraints struct {
+	CA         bool `json:"ca"`
+	MaxPathLen *int `json:"maxPathLen,omitempty"`
+}
+
+func (e BasicConstraints) fields() []field {
+	pathLen := ""
+	if e.MaxPathLen != nil {
+		pathLen = fmt.Sprintf("%v", *e.MaxPathLen)
+	}
+	return []field{{"ca", e.CA}, {"path.len", pathLen}}
+}
+
+// Usages is a decoded keyUsage or extKeyUsage extension.
+type Usages struct {
+	Usages []string `json:"usages"`
+}
+
+func (e Usages) fields() []field {
+	return []field{{"usages", e.Usages}}
+}
+
+// GeneralNames is a decoded subjectAltName or issuerAltName extension.
+type GeneralNames struct {
+	DNSNames       []string `json:"dnsNames,omitempty"`
+	EmailAddresses []string `json:"emailAddresses,omitempty"`
+	IPAddresses    []string `json:"ipAddresses,omitempty"`
+	URIs           []string `json:"uris,omitempty"`
+	DirectoryNames []string `json:"directoryNames,omitempty"`
+	OtherNames     []string `json:"otherNames,omitempty"`
+}
+
+// nonEmpty drops the fields with no names.
+func nonEmpty(fs []field) []field {
+	out := make([]field, 0, len(fs))
+	for _, f := range fs {
+		if names, ok := f.value.([]string); ok && len(names) == 0 {
+			continue
+		}
+		out = append(out, f)
+	}
+	return out
+}
+
+func (e GeneralNames) fields() []field {
+	return nonEmpty([]field{
+		{"dns", e.DNSNames},
+		{"email", e.EmailAddresses},
+		{"ip", e.IPAddresses},
+		{"uri", e.URIs},
+		{"directory", e.DirectoryNames},
+		{"other", e.OtherNames},
+	})
+}
+
+// KeyIdentifier is a decoded subjectKeyIdentifier or
+// authorityKeyIdentifier extension.
+type KeyIdentifier struct {
+	KeyID string `json:"keyId"`
+}
+
+func (e KeyIdentifier) fields() []field {
+	return []field{{"key.id", e.KeyID}}
+}
+
+// DistributionPoints is the decoded cRLDistributionPoints extension.
+type DistributionPoints struct {
+	URIs []string `json:"uris"`
+}
+
+func (e DistributionPoints) fields() []field {
+	return []field{{"uri", e.URIs}}
+}
+
+// AuthorityInfoAccess is the decoded authorityInfoAccess extension.
+type AuthorityInfoAccess struct {
+	OCSP      []string `json:"ocsp"`
+	CAIssuers []string `json:"caIssuers"`
+}
+
+func (e AuthorityInfoAccess) fields() []field {
+	return []field{{"ocsp", e.OCSP}, {"ca.issuers", e.CAIssuers}}
+}
+
+// Policy is one of the certificatePolicies.
+type Policy struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name,omitempty"`
+	CPS         []string `json:"cps,omitempty"`
+	UserNotices []string `json:"userNotices,omitempty"`
+}
+
+// CertificatePolicies is the decoded certificatePolicies extension.
+type CertificatePolicies struct {
+	Policies []Policy `json:"policies"`
+}
+
+func (e CertificatePolicies) fields() []field {
+	fs := make([]field, 0)
+	for i, p := range e.Policies {
+		prefix := fmt.Sprintf("%v.", i)
+		fs = append(fs,
+			field{prefix + "id", p.ID},
+			field{prefix + "name", p.Name},
+			field{prefix + "cps", p.CPS},
+			field{prefix + "user.notice", p.UserNotices})
+	}
+	return fs
+}
+
+// SCT is an embedded signed certificate timestamp (RFC 6962).
+type SCT struct {
+	Version            int       `json:"version"`
+	LogID              string    `json:"logId"`
+	Timestamp          time.Time `json:"timestamp"`
+	HashAlgorithm      string    `json:"hashAlgorithm"`
+	SignatureAlgorithm string    `json:"signatureAlgorithm"`
+	Signature          []byte    `json:"signature"`
+}
+
+// SCTList is the decoded signedCertificateTimestampList extension.
+type SCTList struct {
+	SCTs []SCT `json:"scts"`
+}
+
+func (e SCTList) fields() []field {
+	fs := make([]field, 0)
+	for i, sct := range e.SCTs {
+		prefix := fmt.Sprintf("%v.", i)
+		fs = append(fs,
+			field{prefix + "version", sct.Version},
+			field{prefix + "log.id", sct.LogID},
+			field{prefix + "timestamp", sct.Timestamp},
+			field{prefix + "signature.algorithm", sct.HashAlgorithm + "-" + sct.SignatureAlgorithm})
+	}
+	return fs
+}
+
+// NameConstraints is the decoded nameConstraints extension.
+type NameConstraints struct {
+	PermittedDNSDomains     []string `json:"permittedDnsDomains,omitempty"`
+	ExcludedDNSDomains      []string `json:"excludedDnsDomains,omitempty"`
+	PermittedIPRanges       []string `json:"permittedIpRanges,omitempty"`
+	ExcludedIPRanges        []string `json:"excludedIpRanges,omitempty"`
+	PermittedEmailAddresses []string `json:"permittedEmailAddresses,omitempty"`
+	ExcludedEmailAddresses  []string `json:"excludedEmailAddresses,omitempty"`
+	PermittedURIDomains     []string `json:"permittedUriDomains,omitempty"`
+	ExcludedURIDomains      []string `json:"excludedUriDomains,omitempty"`
+}
+
+func (e NameConstraints) fields() []field {
+	return nonEmpty([]field{
+		{"permitted.dns", e.PermittedDNSDomains},
+		{"excluded.dns", e.ExcludedDNSDomains},
+		{"permitted.ip", e.PermittedIPRanges},
+		{"excluded.ip", e.ExcludedIPRanges},
+		{"permitted.email", e.PermittedEmailAddresses},
+		{"excluded.email", e.ExcludedEmailAddresses},
+		{"permitted.uri", e.PermittedURIDomains},
+		{"excluded.uri", e.ExcludedURIDomains},
+	})
+}
+
+// TLSFeature is the decoded tlsFeature extension (RFC 7633).
+type TLSFeature struct {
+	Features   []string `json:"features"`
+	MustStaple bool     `json:"mustStaple"`
+}
+
+func (e TLSFeature) fields() []field {
+	return []field{{"features", e.Features}, {"must.staple", e.MustStaple}}
+}
+
+// Marker is a decoded extension with no content, such as the
+// precertificate poison.
+type Marker struct{}
+
+func (e Marker) fields() []field {
+	return []field{}
+}
+
+//-----------------------------------------------------------------------------
+
+// hexID formats a key identifier as colon separated hex.
+func hexID(id []byte) string {
+	parts := make([]string, 0, len(id))
+	for _, b := range id {
+		parts = append(parts, fmt.Sprintf("%02X", b))
+	}
+	return strings.Join(parts, ":")
+}
+
+func ipNets(nets []*net.IPNet) []string {
+	out := make([]string, 0, len(nets))
+	for _, n := range nets {
+		out = append(out, n.String())
+	}
+	return out
+}
+
+// decodeString decodes the ASN.1 string types used for display text.
+func decodeString(v asn1.RawValue) string {
+	if v.Tag == asn1.TagBMPString {
+		u := make([]uint16, 0, len(v.Bytes)/2)
+		for i := 0; i+1 < len(v.Bytes); i += 2 {
+			u = append(u, binary.BigEndian.Uint16(v.Bytes[i:]))
+		}
+		return string(utf16.Decode(u))
+	}
+	return string(v.Bytes)
+}
+
+func parseGeneralNames(der []byte) (GeneralNames, error) {
+	var names GeneralNames
+
+	var seq asn1.RawValue
+	if _, err := asn1.Unmarshal(der, &seq); err != nil {
+		return names, err
+	}
+
+	rest := seq.Bytes
+	for len(rest) > 0 {
+		var v asn1.RawValue
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &v); err != nil {
+			return names, err
+		}
+
+		switch v.Tag {
+		case 0:
+			var oid asn1.ObjectIdentifier
+			asn1.Unmarshal(v.Bytes, &oid)
+			names.OtherNames = append(names.OtherNames, oid.String())
+		case 1:
+			names.EmailAddresses = append(names.EmailAddresses, string(v.Bytes))
+		case 2:
+			names.DNSNames = append(names.DNSNames, string(v.Bytes))
+		case 4:
+			var rdn pkix.RDNSequence
+			if _, err := asn1.Unmarshal(v.Bytes, &rdn); err == nil {
+				var name pkix.Name
+				name.FillFromRDNSequence(&rdn)
+				names.DirectoryNames = append(names.DirectoryNames, name.String())
+			}
+		case 6:
+			names.URIs = append(names.URIs, string(v.Bytes))
+		case 7:
+			names.IPAddresses = append(names.IPAddresses, net.IP(v.Bytes).String())
+		}
+	}
+
+	return names, nil
+}
+
+func parsePolicies(der []byte) (CertificatePolicies, error) {
+	var infos []struct {
+		Policy     asn1.ObjectIdentifier
+		Qualifiers []struct {
+			ID        asn1.ObjectIdentifier
+			Qualifier asn1.RawValue
+		} `asn1:"optional"`
+	}
+
+	result := CertificatePolicies{Policies: make([]Policy, 0)}
+
+	if _, err := asn1.Unmarshal(der, &infos); err != nil {
+		return result, err
+	}
+
+	for _, info := range infos {
+		p := Policy{ID: info.Policy.String(), Name: policyNames[info.Policy.String()]}
+
+		for _, q := range info.Qualifiers {
+			switch q.ID.String() {
+
+			case oidQualifierCPS:
+				p.CPS = append(p.CPS, string(q.Qualifier.Bytes))
+
+			case oidQualifierUserNotice:
+				// The notice reference is a sequence, the explicit
+				// text one of the string types.
+				rest := q.Qualifier.Bytes
+				for len(rest) > 0 {
+					var v asn1.RawValue
+					var err error
+					if rest, err = asn1.Unmarshal(rest, &v); err != nil {
+						break
+					}
+					if v.Tag != asn1.TagSequence {
+						p.UserNotices = append(p.UserNotices, decodeString(v))
+					}
+				}
+			}
+		}
+
+		result.Policies = append(result.Policies, p)
+	}
+
+	return result, nil
+}
+
+func parseSCTList(der []byte) (SCTList, error) {
+	result := SCTList{SCTs: make([]SCT, 0)}
+
+	var list []byte
+	if _, err := asn1.Unmarshal(der, &list); err != nil {
+		return result, err
+	}
+
+	short := errors.New("truncated SCT list")
+
+	if len(list) < 2 {
+		return result, short
+	}
+	list = list[2:]
+
+	for len(list) > 0 {
+		if len(list) < 2 {
+			return result, short
+		}
+		n := int(binary.BigEndian.Uint16(list))
+		if len(list) < 2+n {
+			return result, short
+		}
+		sct := list[2 : 2+n]
+		list = list[2+n:]
+
+		// version(1) log id(32) timestamp(8) extensions(2+n)
+		// hash(1) signature alg(1) signature(2+n)
+		if len(sct) < 43 {
+			return result, short
+		}
+		ms := binary.BigEndian.Uint64(sct[33:41])
+		extLen := int(binary.BigEndian.Uint16(sct[41:43]))
+		rest := sct[43:]
+		if len(rest) < extLen+4 {
+			return result, short
+		}
+		rest = rest[extLen:]
+		hash, sig := int(rest[0]), int(rest[1])
+		sigLen := int(binary.BigEndian.Uint16(rest[2:4]))
+		if len(rest) < 4+sigLen {
+			return result, short
+		}
+
+		name := func(names []string, i int) string {
+			if i < len(names) {
+				return names[i]
+			}
+			return fmt.Sprintf("%v", i)
+		}
+
+		result.SCTs = append(result.SCTs, SCT{
+			Version:            int(sct[0]) + 1,
+			LogID:              base64.StdEncoding.EncodeToString(sct[1:33]),
+			Timestamp:          time.Unix(int64(ms/1000), int64(ms%1000)*int64(time.Millisecond)).UTC(),
+			HashAlgorithm:      name(sctHashNames, hash),
+			SignatureAlgorithm: name(sctSignatureNames, sig),
+			Signature:          rest[4 : 4+sigLen],
+		})
+	}
+
+	return result, nil
+}
+
+func parseTLSFeature(der []byte) (TLSFeature, error) {
+	var features []int
+	result := TLSFeature{Features: make([]string, 0)}
+
+	if _, err := asn1.Unmarshal(der, &features); err != nil {
+		return result, err
+	}
+
+	for _, f := range features {
+		name, ok := tlsFeatureNames[f]
+		if !ok {
+			name = fmt.Sprintf("%v", f)
+		}
+		result.Features = append(result.Features, name)
+		if f == 5 {
+			result.MustStaple = true
+		}
+	}
+
+	return result, nil
+}
+
+func parseBasicConstraints(der []byte) (BasicConstraints, error) {
+	var bc struct {
+		IsCA       bool `asn1:"optional"`
+		MaxPathLen int  `asn1:"optional,default:-1"`
+	}
+	if _, err := asn1.Unmarshal(der, &bc); err != nil {
+		return BasicConstraints{}, err
+	}
+
+	result := BasicConstraints{CA: bc.IsCA}
+	if bc.MaxPathLen >= 0 {
+		result.MaxPathLen = &bc.MaxPathLen
+	}
+	return result, nil
+}
+
+func parseKeyUsage(der []byte) (Usages, error) {
+	var bits asn1.BitString
+	if _, err := asn1.Unmarshal(der, &bits); err != nil {
+		return Usages{}, err
+	}
+
+	var usage x509.KeyUsage
+	for i := range keyUsageNames {
+		if bits.At(i) != 0 {
+			usage |= 1 << uint(i)
+		}
+	}
+	return Usages{keyUsages(usage)}, nil
+}
+
+func parseExtKeyUsage(der []byte) (Usages, error) {
+	var oids []asn1.ObjectIdentifier
+	if _, err := asn1.Unmarshal(der, &oids); err != nil {
+		return Usages{}, err
+	}
+
+	usages := make([]string, 0)
+	for _, oid := range oids {
+		if i, ok := extKeyUsageOIDs[oid.String()]; ok {
+			usages = append(usages, extKeyUsageNames[i])
+		} else {
+			usages = append(usages, oid.String())
+		}
+	}
+	return Usages{usages}, nil
+}
+
+func parseKeyIdentifier(der []byte) (KeyIdentifier, error) {
+	var id []byte
+	if _, err := asn1.Unmarshal(der, &id); err != nil {
+		return KeyIdentifier{}, err
+	}
+	return KeyIdentifier{hexID(id)}, nil
+}
+
+// decodeExtension decodes the well known extensions. Most are parsed
+// here; the rest rely on the standard library's parsing of cert, so
+// are left undecoded (nil) if there's no cert, as for a CSR. It
+// returns nil for extensions it doesn't know.
+func decodeExtension(ex pkix.Extension, cert *x509.Certificate) (ExtensionValue, error) {
+
+	switch ex.Id.String() {
+
+	case "2.5.29.19":
+		return parseBasicConstraints(ex.Value)
+
+	case "2.5.29.15":
+		return parseKeyUsage(ex.Value)
+
+	case "2.5.29.37":
+		return parseExtKeyUsage(ex.Value)
+
+	case "2.5.29.14":
+		return parseKeyIdentifier(ex.Value)
+
+	case "2.5.29.17", "2.5.29.18":
+		return parseGeneralNames(ex.Value)
+
+	case "2.5.29.32":
+		return parsePolicies(ex.Value)
+
+	case "1.3.6.1.4.1.11129.2.4.2":
+		return parseSCTList(ex.Value)
+
+	case "1.3.6.1.5.5.7.1.24":
+		return parseTLSFeature(ex.Value)
+
+	case "1.3.6.1.4.1.11129.2.4.3":
+		return Marker{}, nil
+	}
+
+	if cert == nil {
+		return nil, nil
+	}
+
+	switch ex.Id.String() {
+
+	case "2.5.29.35":
+		return KeyIdentifier{hexID(cert.AuthorityKeyId)}, nil
+
+	case "2.5.29.31":
+		return DistributionPoints{strs(cert.CRLDistributionPoints)}, nil
+
+	case "1.3.6.1.5.5.7.1.1":
+		return AuthorityInfoAccess{strs(cert.OCSPServer), strs(cert.IssuingCertificateURL)}, nil
+
+	case "2.5.29.30":
+		return NameConstraints{
+			PermittedDNSDomains:     cert.PermittedDNSDomains,
+			ExcludedDNSDomains:      cert.ExcludedDNSDomains,
+			PermittedIPRanges:       ipNets(cert.PermittedIPRanges),
+			ExcludedIPRanges:        ipNets(cert.ExcludedIPRanges),
+			PermittedEmailAddresses: cert.PermittedEmailAddresses,
+			ExcludedEmailAddresses:  cert.ExcludedEmailAddresses,
+			PermittedURIDomains:     cert.PermittedURIDomains,
+			ExcludedURIDomains:      cert.ExcludedURIDomains,
+		}, nil
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,355 @@
+//
+// Copyright (C) 2017 Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package lib
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+func mustMarshal(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	der, err := asn1.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+// u16 prefixes data with its length, as TLS encodes opaque vectors.
+func u16(data []byte) []byte {
+	out := make([]byte, 2, 2+len(data))
+	binary.BigEndian.PutUint16(out, uint16(len(data)))
+	return append(out, data...)
+}
+
+// testSCT encodes an SCT with the given extensions and signature.
+func testSCT(hash, sig byte, ext, signature []byte) []byte {
+	sct := []byte{0}
+	sct = append(sct, bytes.Repeat([]byte{0xaa}, 32)...)
+	sct = append(sct, 0, 0, 0x01, 0x5c, 0x9e, 0xcc, 0x2f, 0xe8) // 1497312800744 ms
+	sct = append(sct, u16(ext)...)
+	sct = append(sct, hash, sig)
+	return append(sct, u16(signature)...)
+}
+
+func TestParseSCTList(t *testing.T) {
+	good := testSCT(4, 3, nil, []byte{1, 2, 3})
+
+	tests := []struct {
+		name    string
+		list    []byte // the TLS encoded list, or nil
+		der     []byte // used instead of list if set
+		want    int
+		wantErr string
+	}{
+		{"one", u16(u16(good)), nil, 1, ""},
+		{"two", u16(append(u16(good), u16(testSCT(4, 3, []byte{9}, nil))...)), nil, 2, ""},
+		{"empty", u16(nil), nil, 0, ""},
+		{"unknown algorithms", u16(u16(testSCT(42, 9, nil, nil))), nil, 1, ""},
+		{"not an octet string", nil, []byte{0x30, 0x00}, 0, "asn1"},
+		{"bad DER length", nil, []byte{0x04, 0x10, 0x00}, 0, "asn1"},
+		{"no list length", []byte{0}, nil, 0, "truncated SCT list"},
+		{"no SCT length", append(u16(nil), 0), nil, 0, "truncated SCT list"},
+		{"SCT length too long", u16(append([]byte{0x01, 0x00}, good...)), nil, 0, "truncated SCT list"},
+		{"truncated SCT", u16(u16(good[:40])), nil, 0, "truncated SCT list"},
+		{"extension length too long", u16(u16(append(good[:41:41], 0x7f, 0xff))), nil, 0, "truncated SCT list"},
+		{"signature length too long", u16(u16(append(good[:45:45], 0x01, 0x00, 1))), nil, 0, "truncated SCT list"},
+		{"no hash or signature", u16(u16(good[:43])), nil, 0, "truncated SCT list"},
+		{"good then truncated", u16(append(u16(good), u16(good[:20])...)), nil, 1, "truncated SCT list"},
+	}
+
+	for _, test := range tests {
+		der := test.der
+		if der == nil {
+			der = mustMarshal(t, test.list)
+		}
+		got, err := parseSCTList(der)
+		switch {
+		case test.wantErr == "" && err != nil:
+			t.Errorf("%v: %v", test.name, err)
+		case test.wantErr != "" && err == nil:
+			t.Errorf("%v: no error, want one about %q", test.name, test.wantErr)
+		case test.wantErr != "" && !strings.Contains(err.Error(), test.wantErr):
+			t.Errorf("%v: error %q, want one about %q", test.name, err, test.wantErr)
+		}
+		if len(got.SCTs) != test.want {
+			t.Errorf("%v: got %v SCTs, want %v", test.name, len(got.SCTs), test.want)
+		}
+	}
+
+	list, err := parseSCTList(mustMarshal(t, u16(append(u16(good), u16(testSCT(42, 9, nil, nil))...))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sct := list.SCTs[0]
+	if sct.Version != 1 || sct.HashAlgorithm != "sha256" || sct.SignatureAlgorithm != "ecdsa" ||
+		!bytes.Equal(sct.Signature, []byte{1, 2, 3}) || sct.Timestamp.UnixNano() != 1497312800744000000 ||
+		sct.LogID != "qqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqo=" {
+		t.Errorf("got %+v", sct)
+	}
+	if sct := list.SCTs[1]; sct.HashAlgorithm != "42" || sct.SignatureAlgorithm != "9" {
+		t.Errorf("unknown algorithms: got %v-%v, want 42-9", sct.HashAlgorithm, sct.SignatureAlgorithm)
+	}
+}
+
+type testQualifier struct {
+	ID        asn1.ObjectIdentifier
+	Qualifier asn1.RawValue
+}
+
+type testPolicy struct {
+	Policy     asn1.ObjectIdentifier
+	Qualifiers []testQualifier `asn1:"optional"`
+}
+
+func bmpString(s string) asn1.RawValue {
+	b := make([]byte, 0)
+	for _, u := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(u>>8), byte(u))
+	}
+	return asn1.RawValue{Tag: asn1.TagBMPString, Bytes: b}
+}
+
+func TestParsePolicies(t *testing.T) {
+	cps := asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 2, 1}
+	notice := asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 2, 2}
+	dv := asn1.ObjectIdentifier{2, 23, 140, 1, 2, 1}
+	private := asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 1}
+
+	// A user notice of a notice reference and explicit text.
+	ref := mustMarshal(t, struct {
+		Organization string
+		Numbers      []int
+	}{"Example", []int{1, 2}})
+	text := mustMarshal(t, bmpString("Use at your own risk"))
+	userNotice := asn1.RawValue{Tag: asn1.TagSequence, IsCompound: true, Bytes: append(ref, text...)}
+
+	tests := []struct {
+		name    string
+		der     []byte
+		want    []Policy
+		wantErr bool
+	}{
+		{"no qualifiers", mustMarshal(t, []testPolicy{{Policy: dv}}),
+			[]Policy{{ID: "2.23.140.1.2.1", Name: "domainValidated"}}, false},
+		{"CPS and user notice", mustMarshal(t, []testPolicy{{Policy: private, Qualifiers: []testQualifier{
+			{cps, asn1.RawValue{Tag: asn1.TagIA5String, Bytes: []byte("https://example.com/cps")}},
+			{notice, userNotice},
+		}}}), []Policy{{ID: "1.3.6.1.4.1.99999.1", CPS: []string{"https://example.com/cps"},
+			UserNotices: []string{"Use at your own risk"}}}, false},
+		{"other qualifier", mustMarshal(t, []testPolicy{{Policy: dv, Qualifiers: []testQualifier{
+			{private, asn1.RawValue{Tag: asn1.TagInteger, Bytes: []byte{1}}},
+		}}}), []Policy{{ID: "2.23.140.1.2.1", Name: "domainValidated"}}, false},
+		{"user notice not a sequence", mustMarshal(t, []testPolicy{{Policy: dv, Qualifiers: []testQualifier{
+			{notice, asn1.RawValue{Tag: asn1.TagUTF8String, Bytes: []byte("plain")}},
+		}}}), []Policy{{ID: "2.23.140.1.2.1", Name: "domainValidated"}}, false},
+		{"garbled user notice", mustMarshal(t, []testPolicy{{Policy: dv, Qualifiers: []testQualifier{
+			{notice, asn1.RawValue{Tag: asn1.TagSequence, IsCompound: true, Bytes: []byte{0x0c, 0x7f, 'a'}}},
+		}}}), []Policy{{ID: "2.23.140.1.2.1", Name: "domainValidated"}}, false},
+		{"odd length BMP text", mustMarshal(t, []testPolicy{{Policy: dv, Qualifiers: []testQualifier{
+			{notice, asn1.RawValue{Tag: asn1.TagSequence, IsCompound: true,
+				Bytes: []byte{asn1.TagBMPString, 3, 0, 'h', 0}}},
+		}}}), []Policy{{ID: "2.23.140.1.2.1", Name: "domainValidated", UserNotices: []string{"h"}}}, false},
+		{"not a sequence", []byte{0x04, 0x00}, []Policy{}, true},
+		{"bad length prefix", []byte{0x30, 0x84, 0xff, 0xff, 0xff, 0xff}, []Policy{}, true},
+		{"truncated", mustMarshal(t, []testPolicy{{Policy: dv}})[:5], []Policy{}, true},
+	}
+
+	for _, test := range tests {
+		got, err := parsePolicies(test.der)
+		switch {
+		case test.wantErr && err == nil:
+			t.Errorf("%v: no error, want one", test.name)
+		case !test.wantErr && err != nil:
+			t.Errorf("%v: %v", test.name, err)
+		}
+		if !reflect.DeepEqual(got.Policies, test.want) {
+			t.Errorf("%v: got %+v, want %+v", test.name, got.Policies, test.want)
+		}
+	}
+}
+
+func generalName(tag int, value []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: tag, Bytes: value}
+}
+
+func TestParseGeneralNames(t *testing.T) {
+	dirName := mustMarshal(t, pkix.Name{CommonName: "Example", Organization: []string{"Org"}}.ToRDNSequence())
+
+	tests := []struct {
+		name    string
+		der     []byte
+		want    GeneralNames
+		wantErr bool
+	}{
+		{"each type", mustMarshal(t, []asn1.RawValue{
+			generalName(2, []byte("www.example.com")),
+			generalName(1, []byte("admin@example.com")),
+			generalName(6, []byte("https://example.com/")),
+			generalName(7, []byte{192, 0, 2, 1}),
+			{Class: asn1.ClassContextSpecific, Tag: 4, IsCompound: true, Bytes: dirName},
+			{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true,
+				Bytes: mustMarshal(t, asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 20, 2, 3})},
+		}), GeneralNames{
+			DNSNames:       []string{"www.example.com"},
+			EmailAddresses: []string{"admin@example.com"},
+			URIs:           []string{"https://example.com/"},
+			IPAddresses:    []string{"192.0.2.1"},
+			DirectoryNames: []string{"CN=Example,O=Org"},
+			OtherNames:     []string{"1.3.6.1.4.1.311.20.2.3"},
+		}, false},
+		{"unknown tag", mustMarshal(t, []asn1.RawValue{generalName(8, []byte{1}), generalName(2, []byte("a"))}),
+			GeneralNames{DNSNames: []string{"a"}}, false},
+		{"garbled directory name", mustMarshal(t, []asn1.RawValue{
+			{Class: asn1.ClassContextSpecific, Tag: 4, IsCompound: true, Bytes: []byte{0x30, 0x7f}},
+		}), GeneralNames{}, false},
+		{"garbled other name", mustMarshal(t, []asn1.RawValue{
+			{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: []byte{0x06}},
+		}), GeneralNames{OtherNames: []string{""}}, false},
+		{"odd IP", mustMarshal(t, []asn1.RawValue{generalName(7, []byte{1, 2, 3})}),
+			GeneralNames{IPAddresses: []string{"?010203"}}, false},
+		{"empty", []byte{}, GeneralNames{}, true},
+		{"bad length prefix", []byte{0x30, 0x05, 0x82, 0x10, 'a', 'b', 'c'}, GeneralNames{}, true},
+		{"good then bad", []byte{0x30, 0x06, 0x82, 0x01, 'a', 0x82, 0x7f, 'b'},
+			GeneralNames{DNSNames: []string{"a"}}, true},
+	}
+
+	for _, test := range tests {
+		got, err := parseGeneralNames(test.der)
+		switch {
+		case test.wantErr && err == nil:
+			t.Errorf("%v: no error, want one", test.name)
+		case !test.wantErr && err != nil:
+			t.Errorf("%v: %v", test.name, err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: got %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+// TestDecodeMalformed feeds the extensions decoded here truncated and
+// garbled values, which should give errors, not panics.
+func TestDecodeMalformed(t *testing.T) {
+	decoded := []asn1.ObjectIdentifier{
+		{2, 5, 29, 19}, {2, 5, 29, 15}, {2, 5, 29, 37}, {2, 5, 29, 14}, {2, 5, 29, 17},
+		{2, 5, 29, 18}, {2, 5, 29, 32}, {1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}, {1, 3, 6, 1, 5, 5, 7, 1, 24},
+	}
+	tests := []struct {
+		value   []byte
+		wantErr bool // or it's just the wrong type, which needn't be
+	}{
+		{nil, true},
+		{[]byte{0x30}, true},
+		{[]byte{0x30, 0x84, 0xff, 0xff, 0xff, 0xff}, true},
+		{[]byte{0x04, 0x01}, true},
+		{bytes.Repeat([]byte{0xff}, 64), true},
+		{[]byte{0x03, 0x00}, false},
+		{[]byte{0x04, 0x03, 0x00, 0x01, 0xff}, false},
+	}
+
+	for _, id := range decoded {
+		for _, test := range tests {
+			_, err := decodeExtension(pkix.Extension{Id: id, Value: test.value}, nil)
+			if test.wantErr && err == nil {
+				t.Errorf("%v %x: no error", extensionNames[id.String()].name, test.value)
+			}
+		}
+	}
+
+	ca := newTestCA(t, "Test Root", nil, "")
+	leaf := ca.issue(t, "www.example.com", func(c *x509.Certificate) {
+		c.PolicyIdentifiers = []asn1.ObjectIdentifier{{2, 23, 140, 1, 2, 1}}
+		c.CRLDistributionPoints = []string{"http://example.com/crl"}
+		c.OCSPServer = []string{"http://example.com/ocsp"}
+	})
+	for _, ex := range leaf.Extensions {
+		for i := range ex.Value {
+			decodeExtension(pkix.Extension{Id: ex.Id, Value: ex.Value[:i]}, leaf)
+		}
+	}
+}
+
+func TestNewExtensions(t *testing.T) {
+	unknown := pkix.Extension{Id: asn1.ObjectIdentifier{1, 2, 3, 4}, Critical: true, Value: []byte{0x05, 0x00}}
+	bad := pkix.Extension{Id: asn1.ObjectIdentifier{2, 5, 29, 19}, Value: []byte{0x30, 0x7f}}
+	aki := pkix.Extension{Id: asn1.ObjectIdentifier{2, 5, 29, 35}, Value: []byte{0x30, 0x00}}
+
+	got := newExtensions([]pkix.Extension{unknown, bad, aki}, nil)
+
+	// Unknown extensions keep their raw bytes, undecoded.
+	if e := got[0]; e.ID != "1.2.3.4" || e.Name != "" || !e.Critical || e.Decoded != nil ||
+		!bytes.Equal(e.Value, unknown.Value) || e.Error != "" {
+		t.Errorf("unknown: got %+v", e)
+	}
+	if e := got[1]; e.Name != "basicConstraints" || e.Decoded != nil || e.Error == "" ||
+		!bytes.Equal(e.Value, bad.Value) {
+		t.Errorf("malformed: got %+v", e)
+	}
+
+	// Without a cert, as for a CSR, the ones the standard library
+	// parses are left raw.
+	if e := got[2]; e.Name != "authorityKeyIdentifier" || e.Decoded != nil || !bytes.Equal(e.Value, aki.Value) {
+		t.Errorf("no cert: got %+v", e)
+	}
+}
+
+func TestAttributeName(t *testing.T) {
+	tests := []struct {
+		oid  asn1.ObjectIdentifier
+		want string
+	}{
+		{asn1.ObjectIdentifier{2, 5, 4, 3}, "CN"},
+		{asn1.ObjectIdentifier{2, 5, 4, 6}, "C"},
+		{asn1.ObjectIdentifier{2, 5, 4, 10}, "O"},
+		{asn1.ObjectIdentifier{2, 5, 4, 11}, "OU"},
+		{asn1.ObjectIdentifier{2, 5, 4, 97}, "organizationIdentifier"},
+		{asn1.ObjectIdentifier{0, 9, 2342, 19200300, 100, 1, 25}, "DC"},
+		{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 1}, "emailAddress"},
+		{asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 60, 2, 1, 3}, "jurisdictionC"},
+		{asn1.ObjectIdentifier{1, 2, 3, 4}, "1.2.3.4"},
+	}
+
+	for _, test := range tests {
+		if got := attributeName(test.oid); got != test.want {
+			t.Errorf("%v: got %v, want %v", test.oid, got, test.want)
+		}
+	}
+
+	rdns := pkix.Name{ExtraNames: []pkix.AttributeTypeAndValue{
+		{Type: asn1.ObjectIdentifier{0, 9, 2342, 19200300, 100, 1, 25}, Value: "example"},
+		{Type: asn1.ObjectIdentifier{1, 2, 3, 4}, Value: " padded "},
+	}}.ToRDNSequence()
+	var pn pkix.Name
+	pn.FillFromRDNSequence(&rdns)
+	name := newName(pn)
+	want := []Attribute{
+		{Type: "DC", OID: "0.9.2342.19200300.100.1.25", Value: "example"},
+		{Type: "1.2.3.4", OID: "1.2.3.4", Value: "padded"},
+	}
+	if !reflect.DeepEqual(name.Names, want) {
+		t.Errorf("names: got %+v, want %+v", name.Names, want)
+	}
+}
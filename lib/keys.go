@@ -0,0 +1,310 @@
+//
+// Copyright (C) 2017 Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package lib
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"net"
+	"net/url"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Key algorithms GenerateKey accepts.
+const (
+	KeyRSA     = "rsa"
+	KeyECDSA   = "ecdsa"
+	KeyEd25519 = "ed25519"
+)
+
+// GenerateKey makes a new private key. For RSA, bits is the modulus
+// size (default 2048); for ECDSA it picks the curve, 256 (the
+// default), 384 or 521. It's ignored for Ed25519.
+func GenerateKey(algorithm string, bits int) (crypto.Signer, error) {
+	switch strings.ToLower(algorithm) {
+
+	case KeyRSA:
+		if bits == 0 {
+			bits = 2048
+		}
+		return rsa.GenerateKey(rand.Reader, bits)
+
+	case KeyECDSA, "ec":
+		var curve elliptic.Curve
+		switch bits {
+		case 0, 256:
+			curve = elliptic.P256()
+		case 384:
+			curve = elliptic.P384()
+		case 521:
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("no ECDSA curve with %v bits (use 256, 384 or 521)", bits)
+		}
+		return ecdsa.GenerateKey(curve, rand.Reader)
+
+	case KeyEd25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	}
+
+	return nil, fmt.Errorf("unknown key algorithm '%v' (use rsa, ecdsa or ed25519)", algorithm)
+}
+
+// EncodePrivateKey returns key as a PKCS#8 PEM block.
+func EncodePrivateKey(key crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// ParsePrivateKey reads the first private key in PEM data, which may
+// be PKCS#8, PKCS#1 (RSA) or SEC 1 (EC), and may be encrypted with
+// password, either as an encrypted PKCS#8 (PBES2) key or with the
+// older OpenSSL PEM encryption.
+func ParsePrivateKey(data []byte, password string) (crypto.Signer, error) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("no private key found")
+		}
+
+		der := block.Bytes
+
+		switch block.Type {
+		case "ENCRYPTED PRIVATE KEY":
+			if password == "" {
+				return nil, ErrPasswordRequired
+			}
+			plain, err := decryptPKCS8(der, password)
+			if err != nil {
+				return nil, err
+			}
+			// The padding can come out right by chance with the
+			// wrong password, but the key won't parse.
+			key, err := parsePKCS8(plain)
+			if err != nil {
+				return nil, ErrPasswordRequired
+			}
+			return key, nil
+
+		case "PRIVATE KEY", "RSA PRIVATE KEY", "EC PRIVATE KEY":
+			if x509.IsEncryptedPEMBlock(block) {
+				if password == "" {
+					return nil, ErrPasswordRequired
+				}
+				plain, err := x509.DecryptPEMBlock(block, []byte(password))
+				if err != nil {
+					return nil, ErrPasswordRequired
+				}
+				der = plain
+			}
+		}
+
+		switch block.Type {
+		case "PRIVATE KEY":
+			return parsePKCS8(der)
+		case "RSA PRIVATE KEY":
+			return x509.ParsePKCS1PrivateKey(der)
+		case "EC PRIVATE KEY":
+			return x509.ParseECPrivateKey(der)
+		}
+	}
+}
+
+func parsePKCS8(der []byte) (crypto.Signer, error) {
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	return signer, nil
+}
+
+var (
+	oidPBES2  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+)
+
+var pbkdf2Hashes = map[string]func() hash.Hash{
+	"":                    sha1.New, // the default
+	"1.2.840.113549.2.7":  sha1.New,
+	"1.2.840.113549.2.9":  sha256.New,
+	"1.2.840.113549.2.10": sha512.New384,
+	"1.2.840.113549.2.11": sha512.New,
+}
+
+var pbes2Ciphers = map[string]struct {
+	keyLen int
+	block  func([]byte) (cipher.Block, error)
+}{
+	"2.16.840.1.101.3.4.1.2":  {16, aes.NewCipher},
+	"2.16.840.1.101.3.4.1.22": {24, aes.NewCipher},
+	"2.16.840.1.101.3.4.1.42": {32, aes.NewCipher},
+	"1.2.840.113549.3.7":      {24, des.NewTripleDESCipher},
+}
+
+// decryptPKCS8 decrypts a PBES2 EncryptedPrivateKeyInfo (RFC 8018)
+// using PBKDF2 and a CBC mode cipher, which is what OpenSSL writes.
+func decryptPKCS8(der []byte, password string) ([]byte, error) {
+	var info struct {
+		Algo struct {
+			ID     asn1.ObjectIdentifier
+			Params struct {
+				KDF struct {
+					ID     asn1.ObjectIdentifier
+					Params struct {
+						Salt       []byte
+						Iterations int
+						KeyLength  int                      `asn1:"optional"`
+						PRF        pkix.AlgorithmIdentifier `asn1:"optional"`
+					}
+				}
+				Cipher struct {
+					ID asn1.ObjectIdentifier
+					IV []byte
+				}
+			}
+		}
+		Data []byte
+	}
+
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, fmt.Errorf("encrypted private key: %v", err)
+	}
+
+	params := info.Algo.Params
+	if !info.Algo.ID.Equal(oidPBES2) || !params.KDF.ID.Equal(oidPBKDF2) {
+		return nil, fmt.Errorf("encrypted private key: unsupported scheme %v", info.Algo.ID)
+	}
+
+	prf := ""
+	if len(params.KDF.Params.PRF.Algorithm) > 0 {
+		prf = params.KDF.Params.PRF.Algorithm.String()
+	}
+	h, ok := pbkdf2Hashes[prf]
+	if !ok {
+		return nil, fmt.Errorf("encrypted private key: unsupported PRF %v", prf)
+	}
+	c, ok := pbes2Ciphers[params.Cipher.ID.String()]
+	if !ok {
+		return nil, fmt.Errorf("encrypted private key: unsupported cipher %v", params.Cipher.ID)
+	}
+
+	key := pbkdf2.Key([]byte(password), params.KDF.Params.Salt, params.KDF.Params.Iterations, c.keyLen, h)
+	block, err := c.block(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data := info.Data
+	if len(params.Cipher.IV) != block.BlockSize() || len(data) == 0 || len(data)%block.BlockSize() != 0 {
+		return nil, errors.New("encrypted private key: bad IV or data length")
+	}
+
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, params.Cipher.IV).CryptBlocks(plain, data)
+
+	// A wrong password almost always shows up as bad padding.
+	pad := int(plain[len(plain)-1])
+	if pad == 0 || pad > block.BlockSize() {
+		return nil, ErrPasswordRequired
+	}
+	for _, b := range plain[len(plain)-pad:] {
+		if int(b) != pad {
+			return nil, ErrPasswordRequired
+		}
+	}
+
+	return plain[:len(plain)-pad], nil
+}
+
+// DescribePublicKey describes a public key, such as a private key's,
+// the same way as a certificate's.
+func DescribePublicKey(pub crypto.PublicKey) (PublicKey, error) {
+	spki, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return PublicKey{}, err
+	}
+
+	algorithm := x509.UnknownPublicKeyAlgorithm
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		algorithm = x509.RSA
+	case *ecdsa.PublicKey:
+		algorithm = x509.ECDSA
+	case ed25519.PublicKey:
+		algorithm = x509.Ed25519
+	}
+
+	return newPublicKey(algorithm, pub, spki), nil
+}
+
+// AltNames are subject alternative names sorted by kind.
+type AltNames struct {
+	DNSNames       []string
+	EmailAddresses []string
+	IPAddresses    []net.IP
+	URIs           []*url.URL
+}
+
+// ParseAltNames sorts names into DNS names, IP addresses, URIs (which
+// contain "://") and email addresses (which contain "@").
+func ParseAltNames(names []string) (AltNames, error) {
+	var alt AltNames
+	for _, name := range names {
+		if ip := net.ParseIP(name); ip != nil {
+			alt.IPAddresses = append(alt.IPAddresses, ip)
+		} else if strings.Contains(name, "://") {
+			uri, err := url.Parse(name)
+			if err != nil {
+				return alt, err
+			}
+			alt.URIs = append(alt.URIs, uri)
+		} else if strings.Contains(name, "@") {
+			alt.EmailAddresses = append(alt.EmailAddresses, name)
+		} else {
+			alt.DNSNames = append(alt.DNSNames, name)
+		}
+	}
+	return alt, nil
+}
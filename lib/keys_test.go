@@ -0,0 +1,143 @@
+//
+// Copyright (C) 2017 Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package lib
+
+import (
+	"crypto"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// The fixtures in testdata were made with OpenSSL:
+//
+//	openssl genpkey -algorithm EC -pkeyopt ec_paramgen_curve:P-256 -out key.pem
+//	openssl pkcs8 -topk8 -v2 aes-256-cbc -v2prf hmacWithSHA1 -in key.pem -passout pass:sslq-test -out key-aes-256-sha1.pem
+//	openssl pkcs8 -topk8 -v2 des3 -v2prf hmacWithSHA256 -in key.pem -passout pass:sslq-test -out key-des3-sha256.pem
+//	openssl req -x509 -key key.pem -subj /CN=keys.example.com -days 36500 -out cert.pem
+//	openssl pkcs12 -export -in cert.pem -inkey key.pem -passout pass:sslq-test \
+//	    -certpbe PBE-SHA1-3DES -keypbe PBE-SHA1-3DES -macalg sha1 -out store.p12
+//
+// and so on for the other ciphers and PRFs, and the RSA key.
+
+const testPassword = "sslq-test"
+
+func readTestdata(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func readTestKey(t *testing.T, name string) crypto.Signer {
+	t.Helper()
+	key, err := ParsePrivateKey(readTestdata(t, name), "")
+	if err != nil {
+		t.Fatalf("%v: %v", name, err)
+	}
+	return key
+}
+
+type privateKey interface {
+	Equal(crypto.PrivateKey) bool
+}
+
+func TestParseEncryptedPrivateKey(t *testing.T) {
+	tests := []struct {
+		file, plain string
+	}{
+		{"key-aes-256-sha1.pem", "key.pem"},
+		{"key-aes-256-sha256.pem", "key.pem"},
+		{"key-des3-sha1.pem", "key.pem"},
+		{"key-des3-sha256.pem", "key.pem"},
+		{"rsa-key-aes-256-sha256.pem", "rsa-key.pem"},
+	}
+
+	for _, test := range tests {
+		data := readTestdata(t, test.file)
+		want := readTestKey(t, test.plain)
+
+		key, err := ParsePrivateKey(data, testPassword)
+		if err != nil {
+			t.Errorf("%v: %v", test.file, err)
+			continue
+		}
+		if !want.(privateKey).Equal(key) {
+			t.Errorf("%v: decrypted key isn't the one in %v", test.file, test.plain)
+		}
+
+		if key, err := ParsePrivateKey(data, ""); err != ErrPasswordRequired || key != nil {
+			t.Errorf("%v without a password: got %v, %v, want ErrPasswordRequired", test.file, key, err)
+		}
+	}
+}
+
+func TestParseEncryptedPrivateKeyWrongPassword(t *testing.T) {
+	// Each file's lucky password happens to decrypt it to valid padding
+	// (found by trying wrong-0, wrong-1, ...), so only the key parse
+	// catches it.
+	tests := []struct {
+		file  string
+		lucky int
+	}{
+		{"key-aes-256-sha1.pem", 377},
+		{"key-aes-256-sha256.pem", 303},
+		{"key-des3-sha1.pem", 574},
+		{"key-des3-sha256.pem", 1089},
+	}
+
+	for _, test := range tests {
+		data := readTestdata(t, test.file)
+		for _, i := range []int{0, 1, 2, test.lucky} {
+			password := fmt.Sprintf("wrong-%v", i)
+			key, err := ParsePrivateKey(data, password)
+			if err != ErrPasswordRequired || key != nil {
+				t.Errorf("%v with %q: got %v, %v, want ErrPasswordRequired", test.file, password, key, err)
+			}
+		}
+	}
+}
+
+func TestParsePKCS12(t *testing.T) {
+	data := readTestdata(t, "store.p12")
+	want := readTestKey(t, "key.pem")
+
+	certs, keys, err := ParsePKCS12(data, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 1 || CertName(certs[0]) != "keys.example.com" {
+		t.Errorf("got %v certs, want keys.example.com", len(certs))
+	}
+	if len(keys) != 1 || !want.(privateKey).Equal(keys[0]) {
+		t.Errorf("got %v keys, want the one in key.pem", len(keys))
+	}
+
+	if _, _, err := ParsePKCS12(data, "wrong"); err != ErrPasswordRequired {
+		t.Errorf("wrong password: got %v, want ErrPasswordRequired", err)
+	}
+	if _, _, err := ParsePKCS12(readTestdata(t, "key.pem"), testPassword); err == nil {
+		t.Errorf("PEM key parsed as a keystore")
+	}
+
+	if certs, err := ParseCertificates(data, testPassword); err != nil || len(certs) != 1 {
+		t.Errorf("ParseCertificates: got %v certs, %v", len(certs), err)
+	}
+}
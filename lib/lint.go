@@ -0,0 +1,265 @@
+//
+// Copyright (C) 2017 Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package lib
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Lint severities.
+const (
+	LintError   = "error"
+	LintWarning = "warning"
+)
+
+// MaxLeafValidity is the longest a server cert may be valid for under
+// the CA/Browser Forum baseline requirements.
+const MaxLeafValidity = 398 * 24 * time.Hour
+
+// LintFinding is a problem found with one cert in a chain.
+type LintFinding struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Index    int    `json:"index"` // position in the chain, 0 is the leaf
+	Subject  string `json:"subject"`
+	Message  string `json:"message"`
+}
+
+// LintRule checks a chain, leaf first, as of a given time. Check only
+// needs to fill in the index and message of its findings.
+type LintRule struct {
+	Name        string
+	Severity    string
+	Description string
+	Check       func(chain []*x509.Certificate, at time.Time) []LintFinding
+}
+
+// LintRules are the rules Lint applies, in the order they're applied.
+var LintRules = []LintRule{
+	{"validity.too.long", LintWarning, "Leaf valid for longer than 398 days", lintLeaf(checkValidity)},
+	{"signature.sha1", LintError, "Signed with SHA-1 (or worse)", lintIssued(checkSignature)},
+	{"key.rsa.weak", LintError, "RSA key shorter than 2048 bits", lintEach(checkRSAKey)},
+	{"san.missing", LintError, "Leaf has no subject alternative names", lintLeaf(checkSANPresent)},
+	{"cn.not.in.san", LintWarning, "Leaf common name not among its alternative names", lintLeaf(checkCNInSAN)},
+	{"san.wildcard", LintError, "Wildcard not a whole left-most label, or too broad", lintLeaf(checkWildcards)},
+	{"leaf.is.ca", LintError, "Leaf has the CA flag set", lintLeaf(checkLeafCA)},
+	{"eku.server.auth", LintWarning, "Leaf not usable for serverAuth", lintLeaf(checkServerAuth)},
+	{"chain.order", LintWarning, "Cert not issued by the next in the chain", checkChainOrder},
+	{"chain.expired", LintError, "Intermediate expired or not yet valid", lintIssuers(checkExpired)},
+}
+
+// Lint applies LintRules to chain.
+func Lint(chain []*x509.Certificate, at time.Time) []LintFinding {
+	return LintWith(LintRules, chain, at)
+}
+
+// LintWith applies rules to chain, as of at (or now, if it's zero).
+func LintWith(rules []LintRule, chain []*x509.Certificate, at time.Time) []LintFinding {
+	if at.IsZero() {
+		at = time.Now()
+	}
+
+	findings := make([]LintFinding, 0)
+	if len(chain) == 0 {
+		return findings
+	}
+
+	for _, rule := range rules {
+		for _, f := range rule.Check(chain, at) {
+			f.Rule = rule.Name
+			f.Severity = rule.Severity
+			f.Subject = CertName(chain[f.Index])
+			findings = append(findings, f)
+		}
+	}
+	return findings
+}
+
+//-----------------------------------------------------------------------------
+
+// certCheck returns a message if the cert has the problem.
+type certCheck func(cert *x509.Certificate, at time.Time) string
+
+func lintCerts(chain []*x509.Certificate, at time.Time, from, to int, check certCheck) []LintFinding {
+	findings := make([]LintFinding, 0)
+	for i := from; i < to && i < len(chain); i++ {
+		if msg := check(chain[i], at); msg != "" {
+			findings = append(findings, LintFinding{Index: i, Message: msg})
+		}
+	}
+	return findings
+}
+
+// lintLeaf applies check to the leaf.
+func lintLeaf(check certCheck) func([]*x509.Certificate, time.Time) []LintFinding {
+	return func(chain []*x509.Certificate, at time.Time) []LintFinding {
+		return lintCerts(chain, at, 0, 1, check)
+	}
+}
+
+// lintIssuers applies check to each cert after the leaf.
+func lintIssuers(check certCheck) func([]*x509.Certificate, time.Time) []LintFinding {
+	return func(chain []*x509.Certificate, at time.Time) []LintFinding {
+		return lintCerts(chain, at, 1, len(chain), check)
+	}
+}
+
+// lintEach applies check to every cert.
+func lintEach(check certCheck) func([]*x509.Certificate, time.Time) []LintFinding {
+	return func(chain []*x509.Certificate, at time.Time) []LintFinding {
+		return lintCerts(chain, at, 0, len(chain), check)
+	}
+}
+
+// lintIssued applies check to every cert but self-signed roots, as a
+// root's own signature isn't relied on.
+func lintIssued(check certCheck) func([]*x509.Certificate, time.Time) []LintFinding {
+	return func(chain []*x509.Certificate, at time.Time) []LintFinding {
+		findings := lintCerts(chain, at, 0, 1, check)
+		for i, cert := range chain[1:] {
+			if bytes.Equal(cert.RawIssuer, cert.RawSubject) {
+				continue
+			}
+			if msg := check(cert, at); msg != "" {
+				findings = append(findings, LintFinding{Index: i + 1, Message: msg})
+			}
+		}
+		return findings
+	}
+}
+
+func checkValidity(cert *x509.Certificate, at time.Time) string {
+	validity := cert.NotAfter.Sub(cert.NotBefore)
+	if validity > MaxLeafValidity {
+		return fmt.Sprintf("valid for %v days", int(validity.Hours()/24))
+	}
+	return ""
+}
+
+func checkSignature(cert *x509.Certificate, at time.Time) string {
+	switch cert.SignatureAlgorithm {
+	case x509.SHA1WithRSA, x509.DSAWithSHA1, x509.ECDSAWithSHA1,
+		x509.MD5WithRSA, x509.MD2WithRSA:
+		return fmt.Sprintf("signed with %v", cert.SignatureAlgorithm)
+	}
+	return ""
+}
+
+func checkRSAKey(cert *x509.Certificate, at time.Time) string {
+	if key, ok := cert.PublicKey.(*rsa.PublicKey); ok && key.N.BitLen() < 2048 {
+		return fmt.Sprintf("%v bit RSA key", key.N.BitLen())
+	}
+	return ""
+}
+
+func checkSANPresent(cert *x509.Certificate, at time.Time) string {
+	if len(cert.DNSNames) == 0 && len(cert.IPAddresses) == 0 {
+		return "no DNS or IP subject alternative names"
+	}
+	return ""
+}
+
+func checkCNInSAN(cert *x509.Certificate, at time.Time) string {
+	cn := cert.Subject.CommonName
+	if cn == "" {
+		return ""
+	}
+	for _, name := range cert.DNSNames {
+		if strings.EqualFold(name, cn) {
+			return ""
+		}
+	}
+	for _, ip := range cert.IPAddresses {
+		if ip.String() == cn {
+			return ""
+		}
+	}
+	return fmt.Sprintf("common name '%v' not in alternative names", cn)
+}
+
+func checkWildcards(cert *x509.Certificate, at time.Time) string {
+	bad := make([]string, 0)
+	for _, name := range cert.DNSNames {
+		if !strings.Contains(name, "*") {
+			continue
+		}
+		labels := strings.Split(name, ".")
+		// Only "*" as the whole left-most label, with at least two
+		// labels after it (so not *.com).
+		if labels[0] != "*" || strings.Contains(strings.Join(labels[1:], "."), "*") || len(labels) < 3 {
+			bad = append(bad, name)
+		}
+	}
+	if len(bad) > 0 {
+		return fmt.Sprintf("bad wildcard names: %v", strings.Join(bad, ", "))
+	}
+	return ""
+}
+
+func checkLeafCA(cert *x509.Certificate, at time.Time) string {
+	if cert.BasicConstraintsValid && cert.IsCA {
+		return "basic constraints mark the leaf as a CA"
+	}
+	return ""
+}
+
+func checkServerAuth(cert *x509.Certificate, at time.Time) string {
+	if len(cert.ExtKeyUsage) == 0 && len(cert.UnknownExtKeyUsage) == 0 {
+		return "no extended key usages (serverAuth expected)"
+	}
+	for _, usage := range cert.ExtKeyUsage {
+		if usage == x509.ExtKeyUsageServerAuth || usage == x509.ExtKeyUsageAny {
+			return ""
+		}
+	}
+	return "extended key usages don't include serverAuth"
+}
+
+func checkChainOrder(chain []*x509.Certificate, at time.Time) []LintFinding {
+	findings := make([]LintFinding, 0)
+	for i := 0; i+1 < len(chain); i++ {
+		cert, next := chain[i], chain[i+1]
+		if bytes.Equal(cert.RawIssuer, next.RawSubject) && cert.CheckSignatureFrom(next) == nil {
+			continue
+		}
+		msg := fmt.Sprintf("not issued by cert %v (%v)", i+1, CertName(next))
+		if issuer := FindIssuer(cert, chain); issuer != nil {
+			for j, c := range chain {
+				if c == issuer {
+					msg += fmt.Sprintf(", its issuer is cert %v", j)
+				}
+			}
+		}
+		findings = append(findings, LintFinding{Index: i, Message: msg})
+	}
+	return findings
+}
+
+func checkExpired(cert *x509.Certificate, at time.Time) string {
+	if at.After(cert.NotAfter) {
+		return fmt.Sprintf("expired %v", cert.NotAfter.Format(time.RFC3339))
+	}
+	if at.Before(cert.NotBefore) {
+		return fmt.Sprintf("not valid until %v", cert.NotBefore.Format(time.RFC3339))
+	}
+	return ""
+}
@@ -0,0 +1,188 @@
+//
+// Copyright (C) 2017 Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package lib
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// lintResults returns findings as rule@index.
+func lintResults(findings []LintFinding) []string {
+	results := make([]string, 0)
+	for _, f := range findings {
+		results = append(results, fmt.Sprintf("%v@%v", f.Rule, f.Index))
+	}
+	return results
+}
+
+func TestLintRules(t *testing.T) {
+	root := newTestCA(t, "Test Root", nil, "")
+	inter := newTestCA(t, "Test Intermediate", root, "")
+	now := time.Now()
+
+	weak, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An intermediate that expired yesterday.
+	expired := &testCA{Key: newTestKey(t)}
+	expired.Cert = newTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Expired Intermediate"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		NotBefore:             now.Add(-48 * time.Hour),
+		NotAfter:              now.Add(-24 * time.Hour),
+	}, expired.Key, root)
+
+	issue := func(customize func(*x509.Certificate)) *x509.Certificate {
+		return inter.issue(t, "www.example.com", customize)
+	}
+	// Claiming SHA-1 breaks the signature, so it's linted alone to
+	// keep chain.order out of it.
+	sha1 := issue(nil)
+	sha1.SignatureAlgorithm = x509.ECDSAWithSHA1
+
+	tests := []struct {
+		name  string
+		chain []*x509.Certificate
+		want  []string
+	}{
+		{"clean", []*x509.Certificate{issue(nil), inter.Cert, root.Cert}, []string{}},
+		{"validity.too.long", []*x509.Certificate{issue(func(c *x509.Certificate) {
+			c.NotBefore, c.NotAfter = now, now.Add(400*24*time.Hour)
+		}), inter.Cert}, []string{"validity.too.long@0"}},
+		{"signature.sha1", []*x509.Certificate{sha1}, []string{"signature.sha1@0"}},
+		{"key.rsa.weak", []*x509.Certificate{newTestCert(t, &x509.Certificate{
+			Subject:     pkix.Name{CommonName: "www.example.com"},
+			DNSNames:    []string{"www.example.com"},
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}, weak, inter), inter.Cert}, []string{"key.rsa.weak@0"}},
+		{"san.missing", []*x509.Certificate{issue(func(c *x509.Certificate) {
+			c.Subject.CommonName, c.DNSNames = "", nil
+		}), inter.Cert}, []string{"san.missing@0"}},
+		{"cn.not.in.san", []*x509.Certificate{issue(func(c *x509.Certificate) {
+			c.DNSNames = []string{"example.com"}
+		}), inter.Cert}, []string{"cn.not.in.san@0"}},
+		{"san.wildcard", []*x509.Certificate{issue(func(c *x509.Certificate) {
+			c.DNSNames = append(c.DNSNames, "*.com")
+		}), inter.Cert}, []string{"san.wildcard@0"}},
+		{"leaf.is.ca", []*x509.Certificate{issue(func(c *x509.Certificate) {
+			c.IsCA, c.BasicConstraintsValid = true, true
+		}), inter.Cert}, []string{"leaf.is.ca@0"}},
+		{"eku.server.auth", []*x509.Certificate{issue(func(c *x509.Certificate) {
+			c.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+		}), inter.Cert}, []string{"eku.server.auth@0"}},
+		{"chain.order", []*x509.Certificate{issue(nil), root.Cert}, []string{"chain.order@0"}},
+		{"chain.expired", []*x509.Certificate{expired.issue(t, "www.example.com", nil), expired.Cert, root.Cert},
+			[]string{"chain.expired@1"}},
+	}
+
+	covered := make(map[string]bool)
+	for _, test := range tests {
+		findings := Lint(test.chain, now)
+		if got := lintResults(findings); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: got %v, want %v", test.name, got, test.want)
+		}
+		for _, f := range findings {
+			covered[f.Rule] = true
+			if f.Subject != CertName(test.chain[f.Index]) || f.Message == "" {
+				t.Errorf("%v: finding %+v", test.name, f)
+			}
+		}
+	}
+
+	for _, rule := range LintRules {
+		if !covered[rule.Name] {
+			t.Errorf("%v: no test", rule.Name)
+		}
+	}
+}
+
+func TestCheckWildcards(t *testing.T) {
+	tests := []struct {
+		names []string
+		want  string
+	}{
+		{[]string{"www.example.com"}, ""},
+		{[]string{"*.example.com"}, ""},
+		{[]string{"*.example.co.uk", "example.co.uk"}, ""},
+		{[]string{"*.com"}, "*.com"},
+		{[]string{"*"}, "*"},
+		{[]string{"a*.example.com"}, "a*.example.com"},
+		{[]string{"*a.example.com"}, "*a.example.com"},
+		{[]string{"*.*.example.com"}, "*.*.example.com"},
+		{[]string{"www.*.example.com"}, "www.*.example.com"},
+		{[]string{"*.example.com", "*.com", "a*.example.com"}, "*.com, a*.example.com"},
+	}
+
+	for _, test := range tests {
+		got := checkWildcards(&x509.Certificate{DNSNames: test.names}, time.Time{})
+		want := ""
+		if test.want != "" {
+			want = "bad wildcard names: " + test.want
+		}
+		if got != want {
+			t.Errorf("%v: got %q, want %q", strings.Join(test.names, ", "), got, want)
+		}
+	}
+}
+
+func TestCheckChainOrder(t *testing.T) {
+	root := newTestCA(t, "Test Root", nil, "")
+	inter := newTestCA(t, "Test Intermediate", root, "")
+	leaf := inter.issue(t, "www.example.com", nil)
+
+	tests := []struct {
+		name  string
+		chain []*x509.Certificate
+		want  []string
+	}{
+		{"in order", []*x509.Certificate{leaf, inter.Cert, root.Cert}, []string{}},
+		{"shuffled", []*x509.Certificate{leaf, root.Cert, inter.Cert}, []string{
+			"0: not issued by cert 1 (Test Root), its issuer is cert 2",
+			"1: not issued by cert 2 (Test Intermediate)",
+		}},
+		{"reversed", []*x509.Certificate{root.Cert, inter.Cert, leaf}, []string{
+			"0: not issued by cert 1 (Test Intermediate)",
+			"1: not issued by cert 2 (www.example.com), its issuer is cert 0",
+		}},
+		{"missing intermediate", []*x509.Certificate{leaf, root.Cert}, []string{
+			"0: not issued by cert 1 (Test Root)",
+		}},
+		{"just the leaf", []*x509.Certificate{leaf}, []string{}},
+	}
+
+	for _, test := range tests {
+		got := make([]string, 0)
+		for _, f := range checkChainOrder(test.chain, time.Now()) {
+			got = append(got, fmt.Sprintf("%v: %v", f.Index, f.Message))
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+//
+// Copyright (C) 2017 Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package lib
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// Revocation statuses.
+const (
+	RevocationGood    = "good"
+	RevocationRevoked = "revoked"
+	RevocationUnknown = "unknown"
+)
+
+var revocationReasons = []string{"unspecified", "keyCompromise", "cACompromise",
+	"affiliationChanged", "superseded", "cessationOfOperation", "certificateHold",
+	"unused", "removeFromCRL", "privilegeWithdrawn", "aACompromise"}
+
+// Revocation is the result of checking whether a cert is revoked.
+type Revocation struct {
+	Status     string    `json:"status"`
+	Source     string    `json:"source,omitempty"` // staple, ocsp or crl
+	URL        string    `json:"url,omitempty"`
+	ProducedAt time.Time `json:"producedAt,omitzero"`
+	ThisUpdate time.Time `json:"thisUpdate,omitzero"`
+	NextUpdate time.Time `json:"nextUpdate,omitzero"`
+	RevokedAt  time.Time `json:"revokedAt,omitzero"`
+	Reason     string    `json:"reason,omitempty"`
+	Errors     []string  `json:"errors,omitempty"`
+}
+
+func reasonName(code int) string {
+	if code >= 0 && code < len(revocationReasons) {
+		return revocationReasons[code]
+	}
+	return fmt.Sprintf("%v", code)
+}
+
+// ParseRevocationReason returns the CRL reason code with the given
+// name, ignoring case, e.g. "keyCompromise" or "superseded".
+func ParseRevocationReason(name string) (int, error) {
+	for i, reason := range revocationReasons {
+		if i != 7 && strings.EqualFold(reason, name) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown revocation reason '%v'", name)
+}
+
+// CheckRevocation checks whether cert, issued by issuer, is revoked.
+// A stapled OCSP response is used if there is one, then each of the
+// cert's OCSP responders, then its CRLs. Responses are verified
+// against the issuer. Failures along the way are kept in Errors.
+func CheckRevocation(cert, issuer *x509.Certificate, staple []byte, client *http.Client) *Revocation {
+	result := &Revocation{Status: RevocationUnknown}
+	now := time.Now()
+
+	fail := func(source string, err error) {
+		result.Errors = append(result.Errors, fmt.Sprintf("%v: %v", source, err))
+	}
+
+	if issuer == nil {
+		fail("chain", errors.New("issuer not found"))
+		return result
+	}
+
+	useOCSP := func(source, url string, der []byte) bool {
+		resp, err := ocsp.ParseResponseForCert(der, cert, issuer)
+		if err != nil {
+			fail(source, err)
+			return false
+		}
+		if !resp.NextUpdate.IsZero() && resp.NextUpdate.Before(now) {
+			fail(source, fmt.Errorf("stale response (next update was %v)", resp.NextUpdate.Format(time.RFC3339)))
+			return false
+		}
+
+		result.Source = source
+		result.URL = url
+		result.ProducedAt = resp.ProducedAt
+		result.ThisUpdate = resp.ThisUpdate
+		result.NextUpdate = resp.NextUpdate
+
+		switch resp.Status {
+		case ocsp.Good:
+			result.Status = RevocationGood
+		case ocsp.Revoked:
+			result.Status = RevocationRevoked
+			result.RevokedAt = resp.RevokedAt
+			result.Reason = reasonName(resp.RevocationReason)
+		default:
+			// The responder doesn't know, so a CRL might.
+			result.Status = RevocationUnknown
+			return false
+		}
+		return true
+	}
+
+	if len(staple) > 0 && useOCSP("staple", "", staple) {
+		return result
+	}
+
+	for _, url := range cert.OCSPServer {
+		der, err := fetchOCSP(client, url, cert, issuer)
+		if err != nil {
+			fail("ocsp", err)
+			continue
+		}
+		if useOCSP("ocsp", url, der) {
+			return result
+		}
+	}
+
+	for _, url := range cert.CRLDistributionPoints {
+		crl, err := fetchCRL(client, url, issuer)
+		if err != nil {
+			fail("crl", err)
+			continue
+		}
+		if !crl.NextUpdate.IsZero() && crl.NextUpdate.Before(now) {
+			fail("crl", fmt.Errorf("%v: stale CRL (next update was %v)", url, crl.NextUpdate.Format(time.RFC3339)))
+			continue
+		}
+
+		result.Source = "crl"
+		result.URL = url
+		result.ProducedAt = time.Time{}
+		result.ThisUpdate = crl.ThisUpdate
+		result.NextUpdate = crl.NextUpdate
+		result.Status = RevocationGood
+
+		for _, entry := range crl.RevokedCertificateEntries {
+			if entry.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+				result.Status = RevocationRevoked
+				result.RevokedAt = entry.RevocationTime
+				result.Reason = reasonName(entry.ReasonCode)
+				break
+			}
+		}
+		return result
+	}
+
+	return result
+}
+
+func fetchOCSP(client *http.Client, url string, cert, issuer *x509.Certificate) ([]byte, error) {
+	req, err := ocsp.CreateRequest(cert, issuer, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Post(url, "application/ocsp-request", bytes.NewReader(req))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%v: %v", url, resp.Status)
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+func fetchCRL(client *http.Client, url string, issuer *x509.Certificate) (*x509.RevocationList, error) {
+	if !strings.HasPrefix(url, "http") {
+		return nil, fmt.Errorf("%v: unsupported CRL location", url)
+	}
+
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%v: %v", url, resp.Status)
+	}
+
+	der, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if block, _ := pem.Decode(der); block != nil {
+		der = block.Bytes
+	}
+
+	crl, err := x509.ParseRevocationList(der)
+	if err != nil {
+		return nil, err
+	}
+	if err := crl.CheckSignatureFrom(issuer); err != nil {
+		return nil, fmt.Errorf("%v: %v", url, err)
+	}
+
+	return crl, nil
+}
+
+// FindIssuer returns the cert in certs that signed cert, if any.
+func FindIssuer(cert *x509.Certificate, certs []*x509.Certificate) *x509.Certificate {
+	for _, c := range certs {
+		if c == cert || !bytes.Equal(c.RawSubject, cert.RawIssuer) {
+			continue
+		}
+		if cert.CheckSignatureFrom(c) == nil {
+			return c
+		}
+	}
+	return nil
+}
+
+// Properties returns the revocation check as prefix keys to values.
+func (r *Revocation) Properties(prefix string) *FIFOMap {
+	properties := NewFIFOMap()
+
+	stamp := func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format(time.RFC3339)
+	}
+
+	properties.Set(prefix+".status", r.Status)
+	properties.Set(prefix+".source", r.Source)
+	properties.Set(prefix+".url", r.URL)
+	properties.Set(prefix+".produced.at", stamp(r.ProducedAt))
+	properties.Set(prefix+".this.update", stamp(r.ThisUpdate))
+	properties.Set(prefix+".next.update", stamp(r.NextUpdate))
+	if r.Status == RevocationRevoked {
+		properties.Set(prefix+".revoked.at", stamp(r.RevokedAt))
+		properties.Set(prefix+".reason", r.Reason)
+	}
+	properties.Set(prefix+".errors", strings.Join(r.Errors, "; "))
+
+	return properties
+}
@@ -0,0 +1,179 @@
+//
+// Copyright (C) 2017 Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package lib
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+func testOCSPResponse(t *testing.T, signer *testCA, cert *x509.Certificate, status int, nextUpdate time.Time) []byte {
+	t.Helper()
+	now := time.Now()
+	template := ocsp.Response{
+		Status:       status,
+		SerialNumber: cert.SerialNumber,
+		ThisUpdate:   now.Add(-2 * time.Hour),
+		NextUpdate:   nextUpdate,
+	}
+	if status == ocsp.Revoked {
+		template.RevokedAt = now.Add(-time.Hour).Truncate(time.Second)
+		template.RevocationReason = ocsp.KeyCompromise
+	}
+	der, err := ocsp.CreateResponse(signer.Cert, signer.Cert, template, signer.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func testCRL(t *testing.T, signer *testCA, nextUpdate time.Time, revoked ...*big.Int) []byte {
+	t.Helper()
+	now := time.Now()
+	template := &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: now.Add(-2 * time.Hour),
+		NextUpdate: nextUpdate,
+	}
+	for _, serial := range revoked {
+		template.RevokedCertificateEntries = append(template.RevokedCertificateEntries,
+			x509.RevocationListEntry{SerialNumber: serial, RevocationTime: now.Add(-time.Hour), ReasonCode: 4})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, template, signer.Cert, signer.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func TestCheckRevocation(t *testing.T) {
+	ca := newTestCA(t, "Test Root", nil, "")
+	other := newTestCA(t, "Other Root", nil, "")
+
+	later := time.Now().Add(24 * time.Hour)
+	earlier := time.Now().Add(-time.Hour)
+
+	// The stand-in responder answers with whatever the test case says,
+	// or an error status if it has nothing to say.
+	var ocspAnswer, crlAnswer func(cert *x509.Certificate) []byte
+	var leaf *x509.Certificate
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/ocsp" && ocspAnswer != nil:
+			body, _ := ioutil.ReadAll(r.Body)
+			req, err := ocsp.ParseRequest(body)
+			if err != nil || req.SerialNumber.Cmp(leaf.SerialNumber) != 0 {
+				t.Errorf("bad OCSP request: %v", err)
+			}
+			w.Header().Set("Content-Type", "application/ocsp-response")
+			w.Write(ocspAnswer(leaf))
+		case r.URL.Path == "/crl" && crlAnswer != nil:
+			w.Write(crlAnswer(leaf))
+		default:
+			http.Error(w, "not here", http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	leaf = ca.issue(t, "www.example.com", func(c *x509.Certificate) {
+		c.OCSPServer = []string{server.URL + "/ocsp"}
+		c.CRLDistributionPoints = []string{server.URL + "/crl"}
+	})
+
+	ocspWith := func(signer *testCA, status int, next time.Time) func(*x509.Certificate) []byte {
+		return func(cert *x509.Certificate) []byte { return testOCSPResponse(t, signer, cert, status, next) }
+	}
+	crlWith := func(signer *testCA, next time.Time, revoked bool) func(*x509.Certificate) []byte {
+		return func(cert *x509.Certificate) []byte {
+			if revoked {
+				return testCRL(t, signer, next, big.NewInt(1), cert.SerialNumber)
+			}
+			return testCRL(t, signer, next, big.NewInt(1))
+		}
+	}
+
+	tests := []struct {
+		name       string
+		ocsp, crl  func(*x509.Certificate) []byte
+		status     string
+		source     string
+		reason     string
+		errorAbout string
+	}{
+		{"good", ocspWith(ca, ocsp.Good, later), nil, RevocationGood, "ocsp", "", ""},
+		{"revoked", ocspWith(ca, ocsp.Revoked, later), nil, RevocationRevoked, "ocsp", "keyCompromise", ""},
+		{"stale", ocspWith(ca, ocsp.Good, earlier), nil, RevocationUnknown, "", "", "stale response"},
+		{"wrong signer", ocspWith(other, ocsp.Revoked, later), nil, RevocationUnknown, "", "", "ocsp:"},
+		{"crl fallback, good", nil, crlWith(ca, later, false), RevocationGood, "crl", "", "500"},
+		{"crl fallback, revoked", ocspWith(ca, ocsp.Unknown, later), crlWith(ca, later, true),
+			RevocationRevoked, "crl", "superseded", ""},
+		{"crl after stale ocsp", ocspWith(ca, ocsp.Good, earlier), crlWith(ca, later, true),
+			RevocationRevoked, "crl", "superseded", "stale response"},
+		{"stale crl", nil, crlWith(ca, earlier, false), RevocationUnknown, "", "", "stale CRL"},
+		{"crl wrong signer", nil, crlWith(other, later, false), RevocationUnknown, "", "", "crl:"},
+	}
+
+	for _, test := range tests {
+		ocspAnswer, crlAnswer = test.ocsp, test.crl
+
+		r := CheckRevocation(leaf, ca.Cert, nil, server.Client())
+
+		if r.Status != test.status {
+			t.Errorf("%v: status %v, want %v (errors: %v)", test.name, r.Status, test.status, r.Errors)
+		}
+		if test.source != "" && r.Source != test.source {
+			t.Errorf("%v: source %v, want %v", test.name, r.Source, test.source)
+		}
+		if r.Reason != test.reason {
+			t.Errorf("%v: reason %q, want %q", test.name, r.Reason, test.reason)
+		}
+		errors := strings.Join(r.Errors, "; ")
+		if test.errorAbout == "" && errors != "" {
+			t.Errorf("%v: unexpected errors: %v", test.name, errors)
+		}
+		if !strings.Contains(errors, test.errorAbout) {
+			t.Errorf("%v: errors %q, want one about %q", test.name, errors, test.errorAbout)
+		}
+	}
+}
+
+func TestCheckRevocationStaple(t *testing.T) {
+	ca := newTestCA(t, "Test Root", nil, "")
+	leaf := ca.issue(t, "www.example.com", func(c *x509.Certificate) {
+		c.OCSPServer = []string{"http://127.0.0.1:1/ocsp"}
+	})
+
+	staple := testOCSPResponse(t, ca, leaf, ocsp.Good, time.Now().Add(time.Hour))
+	r := CheckRevocation(leaf, ca.Cert, staple, http.DefaultClient)
+	if r.Status != RevocationGood || r.Source != "staple" || len(r.Errors) != 0 {
+		t.Errorf("stapled: got %v from %v (errors: %v), want good from the staple", r.Status, r.Source, r.Errors)
+	}
+
+	if r := CheckRevocation(leaf, nil, staple, http.DefaultClient); r.Status != RevocationUnknown {
+		t.Errorf("no issuer: got %v, want unknown", r.Status)
+	}
+}
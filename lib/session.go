@@ -0,0 +1,146 @@
+//
+// Copyright (C) 2017 Keith Irwin
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published
+// by the Free Software Foundation, either version 3 of the License,
+// or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package lib
+
+import (
+	"crypto/tls"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// TLSSession describes the parameters negotiated during a handshake.
+type TLSSession struct {
+	Version      string   `json:"version"`
+	CipherSuite  string   `json:"cipherSuite"`
+	ServerName   string   `json:"serverName,omitempty"`
+	ALPN         string   `json:"alpn,omitempty"`
+	OCSPResponse []byte   `json:"ocspResponse,omitempty"`
+	SCTs         [][]byte `json:"scts,omitempty"`
+
+	// ClientAuth is set when the server asked for a client cert.
+	ClientAuth *ClientAuthRequest `json:"clientAuth,omitempty"`
+}
+
+// ClientAuthRequest describes a server's CertificateRequest: the CAs
+// it will accept client certs from (any, if none are given) and the
+// signature schemes it will verify.
+type ClientAuthRequest struct {
+	AcceptableCAs    []string `json:"acceptableCAs"`
+	SignatureSchemes []string `json:"signatureSchemes"`
+	CertError        string   `json:"certError,omitempty"`
+}
+
+// signatureSchemeNames names the schemes servers commonly offer that
+// Go doesn't implement (and so doesn't name).
+var signatureSchemeNames = map[tls.SignatureScheme]string{
+	0x0301: "PKCS1WithSHA224",
+	0x0303: "ECDSAWithSHA224",
+	0x0808: "Ed448",
+	0x0809: "PSSPSSWithSHA256",
+	0x080a: "PSSPSSWithSHA384",
+	0x080b: "PSSPSSWithSHA512",
+	0x081a: "ECDSABrainpoolP256r1TLS13WithSHA256",
+	0x081b: "ECDSABrainpoolP384r1TLS13WithSHA384",
+	0x081c: "ECDSABrainpoolP512r1TLS13WithSHA512",
+}
+
+func signatureSchemeName(scheme tls.SignatureScheme) string {
+	if name, ok := signatureSchemeNames[scheme]; ok {
+		return name
+	}
+	name := scheme.String()
+	if strings.HasPrefix(name, "SignatureScheme(") {
+		return fmt.Sprintf("0x%04x", uint16(scheme))
+	}
+	return name
+}
+
+// NewClientAuthRequest describes info. If a client cert is given, the
+// reason it doesn't meet the request, if any, is noted.
+func NewClientAuthRequest(info *tls.CertificateRequestInfo, cert *tls.Certificate) *ClientAuthRequest {
+	r := &ClientAuthRequest{
+		AcceptableCAs:    make([]string, 0, len(info.AcceptableCAs)),
+		SignatureSchemes: make([]string, 0, len(info.SignatureSchemes)),
+	}
+
+	for _, der := range info.AcceptableCAs {
+		var rdns pkix.RDNSequence
+		if _, err := asn1.Unmarshal(der, &rdns); err != nil {
+			r.AcceptableCAs = append(r.AcceptableCAs, fmt.Sprintf("%X", der))
+			continue
+		}
+		var name pkix.Name
+		name.FillFromRDNSequence(&rdns)
+		r.AcceptableCAs = append(r.AcceptableCAs, name.String())
+	}
+
+	for _, scheme := range info.SignatureSchemes {
+		r.SignatureSchemes = append(r.SignatureSchemes, signatureSchemeName(scheme))
+	}
+
+	if cert != nil {
+		if err := info.SupportsCertificate(cert); err != nil {
+			r.CertError = err.Error()
+		}
+	}
+
+	return r
+}
+
+// NewTLSSession returns the session parameters from a connection state.
+func NewTLSSession(state tls.ConnectionState) *TLSSession {
+	return &TLSSession{
+		Version:      tls.VersionName(state.Version),
+		CipherSuite:  tls.CipherSuiteName(state.CipherSuite),
+		ServerName:   state.ServerName,
+		ALPN:         state.NegotiatedProtocol,
+		OCSPResponse: state.OCSPResponse,
+		SCTs:         state.SignedCertificateTimestamps,
+	}
+}
+
+// Properties returns the session as "tls." keys to values.
+func (s *TLSSession) Properties() *FIFOMap {
+	properties := NewFIFOMap()
+
+	properties.Set("tls.version", s.Version)
+	properties.Set("tls.cipher.suite", s.CipherSuite)
+	properties.Set("tls.server.name", s.ServerName)
+	properties.Set("tls.alpn.protocol", s.ALPN)
+	properties.Set("tls.ocsp.staple", base64.StdEncoding.EncodeToString(s.OCSPResponse))
+	properties.Set("tls.scts", fmt.Sprintf("%v", len(s.SCTs)))
+	for i, sct := range s.SCTs {
+		properties.Set(fmt.Sprintf("tls.scts.%v", i), base64.StdEncoding.EncodeToString(sct))
+	}
+
+	properties.Set("tls.client.auth.requested", fmt.Sprintf("%v", s.ClientAuth != nil))
+	if r := s.ClientAuth; r != nil {
+		properties.Set("tls.client.auth.acceptable.cas", fmt.Sprintf("%v", len(r.AcceptableCAs)))
+		for i, ca := range r.AcceptableCAs {
+			properties.Set(fmt.Sprintf("tls.client.auth.acceptable.cas.%v", i), ca)
+		}
+		properties.Set("tls.client.auth.signature.schemes", strings.Join(r.SignatureSchemes, ", "))
+		if r.CertError != "" {
+			properties.Set("tls.client.auth.cert.error", r.CertError)
+		}
+	}
+
+	return properties
+}